@@ -3,9 +3,11 @@ package main
 import (
 	"flag"
 	"image"
-	"image/jpeg"
 	"log/slog"
 	"os"
+
+	_ "github.com/yunomu/jpeg/decoder/register"
+	"github.com/yunomu/jpeg/encoder"
 )
 
 func init() {
@@ -21,8 +23,8 @@ func main() {
 
 	slog.Info("image.Decode", "format", t)
 
-	if err := jpeg.Encode(os.Stdout, m, nil); err != nil {
-		slog.Error("jpeg.Encode", "err", err)
+	if err := encoder.Encode(os.Stdout, m, nil); err != nil {
+		slog.Error("encoder.Encode", "err", err)
 		return
 	}
 }
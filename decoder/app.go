@@ -0,0 +1,73 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+)
+
+// adobeSegment is the Adobe APP14 segment, which tells the color transform of the components.
+type adobeSegment struct {
+	version        uint16
+	flags0, flags1 uint16
+	transform      uint8
+}
+
+const (
+	adobeTransformNone  = 0 // RGB or CMYK
+	adobeTransformYCbCr = 1
+	adobeTransformYCCK  = 2
+)
+
+func (a *adobeSegment) String() string {
+	return fmt.Sprintf("(version=%d flags0=%#x flags1=%#x transform=%d)", a.version, a.flags0, a.flags1, a.transform)
+}
+
+// parseAdobe parses the data of an APP14 segment. It returns nil if the segment is not of Adobe.
+func parseAdobe(data []byte) *adobeSegment {
+	if len(data) < 12 || !bytes.HasPrefix(data, []byte("Adobe")) {
+		return nil
+	}
+
+	return &adobeSegment{
+		version:   uint16(data[5])<<8 | uint16(data[6]),
+		flags0:    uint16(data[7])<<8 | uint16(data[8]),
+		flags1:    uint16(data[9])<<8 | uint16(data[10]),
+		transform: data[11],
+	}
+}
+
+// readAPP reads an application segment and keeps those the decoder interprets.
+func (d *Decoder) readAPP(m Marker) error {
+	l, err := d.readUint16()
+	if err != nil {
+		return err
+	}
+
+	if l < 2 {
+		return ErrUnexpectedByte
+	}
+
+	data, err := d.readBytes(int(l - 2))
+	if err != nil {
+		return err
+	}
+
+	slog.Info("application segment",
+		"marker", m,
+		"length", l,
+	)
+
+	if m == Marker_APP_n && bytes.HasPrefix(data, []byte("JFIF\x00")) {
+		d.jfif = true
+	}
+
+	if m == Marker_APP_n+14 {
+		if a := parseAdobe(data); a != nil {
+			slog.Info("Adobe", "segment", a)
+			d.adobe = a
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,528 @@
+package decoder
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/yunomu/jpeg/jpeg"
+)
+
+var qeTable = jpeg.QeTable
+
+const fixedBin = 113
+
+var (
+	ErrInvalidConditioning = errors.New("invalid arithmetic conditioning table")
+	ErrArithmeticOverflow  = errors.New("arithmetic-coded data overflow")
+)
+
+// conditioningTable is an arithmetic coding conditioning table defined by DAC.
+type conditioningTable struct {
+	class  uint8
+	target uint8
+	l, u   uint8 // lower and upper bounds for DC and lossless coding
+	kx     uint8 // AC band boundary
+}
+
+func (t *conditioningTable) String() string {
+	return fmt.Sprintf("(Tc=%d Tb=%d L=%d U=%d Kx=%d)", t.class, t.target, t.l, t.u, t.kx)
+}
+
+func findConditioningTable(tables []*conditioningTable, class, target uint8) *conditioningTable {
+	for _, t := range tables {
+		if t.class == class && t.target == target {
+			return t
+		}
+	}
+
+	// default conditioning (F.1.4.4.1.4, F.1.4.4.2.1)
+	return &conditioningTable{
+		class:  class,
+		target: target,
+		l:      0,
+		u:      1,
+		kx:     5,
+	}
+}
+
+func replaceConditioningTable(tables []*conditioningTable, t *conditioningTable) []*conditioningTable {
+	ret := []*conditioningTable{t}
+	for _, t1 := range tables {
+		if t1.class != t.class || t1.target != t.target {
+			ret = append(ret, t1)
+		}
+	}
+	return ret
+}
+
+func (d *Decoder) readDAC() ([]*conditioningTable, error) {
+	la, err := d.readUint16()
+	if err != nil {
+		return nil, err
+	}
+
+	if la < 2 || (la-2)%2 != 0 {
+		return nil, ErrInvalidConditioning
+	}
+
+	var ret []*conditioningTable
+	for i := 0; i < int(la-2)/2; i++ {
+		t, err := d.readUint8()
+		if err != nil {
+			return nil, err
+		}
+
+		cs, err := d.readUint8()
+		if err != nil {
+			return nil, err
+		}
+
+		ct := &conditioningTable{
+			class:  t >> 4,
+			target: t & 0x0F,
+			l:      cs & 0x0F,
+			u:      cs >> 4,
+			kx:     cs,
+		}
+		switch ct.class {
+		case 0:
+			if ct.l > ct.u {
+				return nil, ErrInvalidConditioning
+			}
+			ct.kx = 5
+		case 1:
+			if ct.kx < 1 || ct.kx > 63 {
+				return nil, ErrInvalidConditioning
+			}
+			ct.l, ct.u = 0, 1
+		default:
+			return nil, ErrInvalidConditioning
+		}
+
+		ret = append(ret, ct)
+	}
+
+	slog.Info("define arithmetic conditioning",
+		"La", la,
+		"tables", ret,
+	)
+
+	return ret, nil
+}
+
+// arithDecoder holds the state of the QM-coder decoder and its statistics areas.
+type arithDecoder struct {
+	c, a int
+	ct   int
+	err  error
+
+	dcStats [4][256]uint8 // DC and lossless statistics areas
+	acStats [4][256]uint8
+	fixed   uint8
+
+	dcContext map[uint8]int
+}
+
+// arithByte reads a byte of the arithmetic-coded data. A marker found in the
+// data is left unread, and zero bytes are supplied instead (D.2.6).
+func (d *Decoder) arithByte() int {
+	b, err := d.readByte()
+	if err == ErrUnexpectedMarker {
+		d.unread()
+		return 0
+	} else if err != nil {
+		if d.arith.err == nil {
+			d.arith.err = err
+		}
+		return 0
+	}
+
+	return int(b)
+}
+
+// arithDecode decodes a binary decision with the statistics bin st (D.2).
+func (d *Decoder) arithDecode(st *uint8) int {
+	e := &d.arith
+
+	// renormalization and data input (D.2.6)
+	for e.a < 0x8000 {
+		e.ct--
+		if e.ct < 0 {
+			e.c = e.c<<8 | d.arithByte()
+			e.ct += 8
+			if e.ct < 0 {
+				// reading the two initial bytes
+				e.ct++
+				if e.ct == 0 {
+					e.a = 0x8000
+				}
+			}
+		}
+		e.a <<= 1
+	}
+
+	sv := int(*st)
+	q := qeTable[sv&0x7F]
+	qe := int(q >> 16)
+	nl := uint8(q)
+	nm := uint8(q >> 8)
+
+	// decoding and probability estimation (D.2.4, D.2.5)
+	temp := e.a - qe
+	e.a = temp
+	temp <<= e.ct
+	if e.c >= temp {
+		e.c -= temp
+		// conditional exchange of LPS
+		if e.a < qe {
+			e.a = qe
+			*st = uint8(sv&0x80) ^ nm
+		} else {
+			e.a = qe
+			*st = uint8(sv&0x80) ^ nl
+			sv ^= 0x80
+		}
+	} else if e.a < 0x8000 {
+		// conditional exchange of MPS
+		if e.a < qe {
+			*st = uint8(sv&0x80) ^ nl
+			sv ^= 0x80
+		} else {
+			*st = uint8(sv&0x80) ^ nm
+		}
+	}
+
+	return sv >> 7
+}
+
+// resetArith initializes the decoder and the statistics areas used by the scan
+// at the beginning of a scan or a restart interval.
+func (d *Decoder) resetArith(scanHeader *scanHeader, params []*componentParam) {
+	e := &d.arith
+	e.c = 0
+	e.a = 0
+	e.ct = -16
+	e.fixed = fixedBin
+	e.dcContext = make(map[uint8]int)
+
+	for _, param := range params {
+		if !d.progressive || (scanHeader.ss == 0 && scanHeader.ah == 0) {
+			e.dcStats[param.td&3] = [256]uint8{}
+		}
+		if !d.progressive || scanHeader.ss != 0 {
+			e.acStats[param.ta&3] = [256]uint8{}
+		}
+	}
+}
+
+// decodeArithMagnitude decodes the rest of the magnitude category of a nonzero
+// value from m with the X bins in xs (Figure F.23), followed by its bit pattern
+// with the M bin 14 bins after the last X bin (Figure F.24).
+func (d *Decoder) decodeArithMagnitude(m int32, xs []uint8) (int32, error) {
+	var xi int
+	for d.arithDecode(&xs[xi]) != 0 {
+		m <<= 1
+		if m == 0x8000 {
+			return 0, ErrArithmeticOverflow
+		}
+		xi++
+	}
+
+	v := m
+	st := &xs[xi+14]
+	for m >>= 1; m != 0; m >>= 1 {
+		if d.arithDecode(st) != 0 {
+			v |= m
+		}
+	}
+
+	return v + 1, nil
+}
+
+// conditioningCategory classifies a difference into the zero, small positive,
+// small negative, large positive or large negative category (F.1.4.4.1.2).
+func conditioningCategory(diff int32, ct *conditioningTable) int {
+	mag := diff
+	if mag < 0 {
+		mag = -mag
+	}
+
+	var cat int
+	switch {
+	case mag <= (1<<ct.l)>>1:
+		return 0
+	case mag > 1<<ct.u:
+		cat = 3
+	default:
+		cat = 1
+	}
+
+	if diff < 0 {
+		cat++
+	}
+	return cat
+}
+
+// decodeArithDC decodes a DC difference (F.2.4.1).
+func (d *Decoder) decodeArithDC(param *componentParam) (int32, error) {
+	e := &d.arith
+	stats := e.dcStats[param.td&3][:]
+	s0 := e.dcContext[param.cs]
+
+	if d.arithDecode(&stats[s0]) == 0 {
+		e.dcContext[param.cs] = 0
+		return 0, nil
+	}
+
+	sign := d.arithDecode(&stats[s0+1])
+
+	v := int32(1)
+	if d.arithDecode(&stats[s0+2+sign]) != 0 {
+		v1, err := d.decodeArithMagnitude(1, stats[20:])
+		if err != nil {
+			return 0, err
+		}
+		v = v1
+	}
+	if sign != 0 {
+		v = -v
+	}
+
+	// conditioning category for the next DC difference
+	e.dcContext[param.cs] = 4 * conditioningCategory(v, param.dcCond)
+
+	return v, nil
+}
+
+// decodeArithAC skips zero coefficients from k and decodes the next nonzero
+// AC coefficient (F.2.4.2). k is updated to the index of the coefficient.
+func (d *Decoder) decodeArithAC(param *componentParam, se int, k *int) (int32, error) {
+	e := &d.arith
+	stats := e.acStats[param.ta&3][:]
+
+	st := 3 * (*k - 1)
+	for d.arithDecode(&stats[st+1]) == 0 {
+		st += 3
+		*k++
+		if *k > se {
+			return 0, errors.New("coefficient index out of range")
+		}
+	}
+
+	sign := d.arithDecode(&e.fixed)
+
+	// X1 and X2 share the bin after S0
+	v := int32(1)
+	if d.arithDecode(&stats[st+2]) != 0 {
+		v = 2
+		if d.arithDecode(&stats[st+2]) != 0 {
+			xs := stats[217:]
+			if *k <= int(param.acCond.kx) {
+				xs = stats[189:]
+			}
+
+			v1, err := d.decodeArithMagnitude(2, xs)
+			if err != nil {
+				return 0, err
+			}
+			v = v1
+		}
+	}
+	if sign != 0 {
+		v = -v
+	}
+
+	return v, nil
+}
+
+func (d *Decoder) decodeArithDataUnit(param *componentParam, zz *block) error {
+	dc, err := d.decodeArithDC(param)
+	if err != nil {
+		return err
+	}
+	var acs block
+	acs[0] = d.dcValue(param.cs, dc)
+	stats := d.arith.acStats[param.ta&3][:]
+	for k := 1; k <= 63; k++ {
+		// EOB decision
+		if d.arithDecode(&stats[3*(k-1)]) != 0 {
+			break
+		}
+
+		v, err := d.decodeArithAC(param, 63, &k)
+		if err != nil {
+			return err
+		}
+		acs[k] = v
+	}
+	*zz = acs
+
+	return d.arith.err
+}
+
+func (d *Decoder) decodeArithDCFirst(param *componentParam, al uint8, zz *block) error {
+	dc, err := d.decodeArithDC(param)
+	if err != nil {
+		return err
+	}
+	zz[0] = d.dcValue(param.cs, dc) << al
+
+	return d.arith.err
+}
+
+func (d *Decoder) decodeArithDCRefine(al uint8, zz *block) error {
+	if d.arithDecode(&d.arith.fixed) != 0 {
+		zz[0] |= 1 << al
+	}
+
+	return d.arith.err
+}
+
+func (d *Decoder) decodeArithACFirst(param *componentParam, h *scanHeader, zz *block) error {
+	stats := d.arith.acStats[param.ta&3][:]
+	for k := int(h.ss); k <= int(h.se); k++ {
+		// EOB decision
+		if d.arithDecode(&stats[3*(k-1)]) != 0 {
+			break
+		}
+
+		v, err := d.decodeArithAC(param, int(h.se), &k)
+		if err != nil {
+			return err
+		}
+		zz[k] = v << h.al
+	}
+
+	return d.arith.err
+}
+
+func (d *Decoder) decodeArithACRefine(param *componentParam, h *scanHeader, zz *block) error {
+	stats := d.arith.acStats[param.ta&3][:]
+	p1 := int32(1) << h.al
+	se := int(h.se)
+
+	// end of block in the previous stage
+	kex := se
+	for ; kex > 0; kex-- {
+		if zz[kex] != 0 {
+			break
+		}
+	}
+
+	for k := int(h.ss); k <= se; k++ {
+		st := 3 * (k - 1)
+		if k > kex && d.arithDecode(&stats[st]) != 0 {
+			// EOB
+			break
+		}
+
+		for {
+			if zz[k] != 0 {
+				// correction bit of a previously nonzero coefficient
+				if d.arithDecode(&stats[st+2]) != 0 {
+					if zz[k] < 0 {
+						zz[k] -= p1
+					} else {
+						zz[k] += p1
+					}
+				}
+				break
+			}
+
+			if d.arithDecode(&stats[st+1]) != 0 {
+				// newly nonzero coefficient
+				if d.arithDecode(&d.arith.fixed) != 0 {
+					zz[k] = -p1
+				} else {
+					zz[k] = p1
+				}
+				break
+			}
+
+			st += 3
+			k++
+			if k > se {
+				return errors.New("coefficient index out of range")
+			}
+		}
+	}
+
+	return d.arith.err
+}
+
+func (d *Decoder) decodeArithUnit(h *scanHeader, param *componentParam, zz *block) error {
+	if !d.progressive {
+		return d.decodeArithDataUnit(param, zz)
+	}
+
+	switch {
+	case h.ss == 0 && h.ah == 0:
+		return d.decodeArithDCFirst(param, h.al, zz)
+	case h.ss == 0:
+		return d.decodeArithDCRefine(h.al, zz)
+	case h.ah == 0:
+		return d.decodeArithACFirst(param, h, zz)
+	default:
+		return d.decodeArithACRefine(param, h, zz)
+	}
+}
+
+// decodeArithDiff decodes a difference of the lossless process with the
+// two-dimensional context of the differences Da and Db of the neighboring
+// samples (H.1.4.3).
+func (d *Decoder) decodeArithDiff(param *componentParam, x, y int) (int32, error) {
+	stats := d.arith.dcStats[param.td&3][:]
+
+	var da, db int32
+	if x != 0 && !(x == param.x0 && y == param.y0) {
+		da = param.diffs[y*param.fp.stride+x-1]
+	}
+	if y != param.y0 {
+		db = param.diffs[(y-1)*param.fp.stride+x]
+	}
+
+	cb := conditioningCategory(db, param.dcCond)
+	s0 := 4 * (5*conditioningCategory(da, param.dcCond) + cb)
+
+	var v int32
+	if d.arithDecode(&stats[s0]) != 0 {
+		sign := d.arithDecode(&stats[s0+1])
+
+		v = 1
+		if d.arithDecode(&stats[s0+2+sign]) != 0 {
+			// the X bins are conditioned on whether Db is large
+			xs := stats[100:]
+			if cb >= 3 {
+				xs = stats[129:]
+			}
+
+			v1, err := d.decodeArithMagnitude(1, xs)
+			if err != nil {
+				return 0, err
+			}
+			v = v1
+		}
+		if sign != 0 {
+			v = -v
+		}
+	}
+	param.diffs[y*param.fp.stride+x] = v
+
+	return v, d.arith.err
+}
+
+// skipToMarker discards the rest of the arithmetic-coded data up to the next marker.
+func (d *Decoder) skipToMarker() error {
+	for {
+		_, m, err := d.readByteMarker()
+		if err != nil {
+			return err
+		}
+
+		if m != 0 {
+			d.unread()
+			return nil
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package decoder
+
+import (
+	"testing"
+)
+
+func TestDecode_arithmetic(t *testing.T) {
+	// same coefficients as the Huffman-coded progressive.jpg
+	exp := decodeFile(t, "testdata/progressive.jpg")
+
+	for _, name := range []string{
+		"testdata/arith.jpg",             // sequential, restart every 5 MCUs
+		"testdata/arith_progressive.jpg", // progressive with DAC
+	} {
+		act := decodeFile(t, name)
+		compareImage(t, exp, act, 0)
+	}
+}
+
+func TestDecode_arithmeticLossless(t *testing.T) {
+	// 12-bit, 3 components with 2x2 sampled first component, DAC and restart
+	h := decodeFrameFile(t, "testdata/lossless_arith.jpg")
+
+	if !h.isArithmetic() || !h.isLossless() {
+		t.Fatalf("marker=%v", h.marker)
+	}
+
+	for i := 0; i < 3; i++ {
+		checkLosslessComponent(t, h, i, 0)
+	}
+}
+
+func TestConditioningCategory(t *testing.T) {
+	ct := &conditioningTable{l: 1, u: 3}
+	for _, c := range []struct {
+		diff int32
+		exp  int
+	}{
+		{0, 0},
+		{1, 0},
+		{-1, 0},
+		{2, 1},
+		{-2, 2},
+		{8, 1},
+		{-8, 2},
+		{9, 3},
+		{-9, 4},
+	} {
+		if act := conditioningCategory(c.diff, ct); act != c.exp {
+			t.Errorf("conditioningCategory(%d)=%d exp=%d", c.diff, act, c.exp)
+		}
+	}
+}
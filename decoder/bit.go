@@ -21,55 +21,85 @@ func (d *Decoder) readDNL() (uint16, error) {
 	return d.readUint16()
 }
 
-func (d *Decoder) nextBit() (uint16, error) {
-	if d.bitMask == 0 {
-		b, err := d.readUint8()
-		if err == ErrUnexpectedMarker {
+// fill reads the bytes of the entropy-coded data into the accumulator until it
+// has more than 56 bits. The stuffed 0x00 after 0xFF is removed by readByteMarker.
+// A marker ends the data, and is left to be read by readMarker.
+func (d *Decoder) fill() {
+	for d.nacc <= 56 && d.accEnd == nil {
+		b, m, err := d.readByteMarker()
+		if err != nil {
+			d.accEnd = err
+			return
+		}
+		if m != 0 {
 			d.unread()
-			m, err := d.readMarker()
-			if err != nil {
-				return 0, err
-			}
+			d.accEnd = ErrUnexpectedMarker
+			return
+		}
 
-			if m == Marker_DNL {
-				l, err := d.readDNL()
-				if err != nil {
-					return 0, err
-				}
+		d.acc |= uint64(b) << (56 - d.nacc)
+		d.nacc += 8
+	}
+}
 
-				d.numLine = l
+// endOfData returns the error of reading over the end of the entropy-coded data.
+// It is EOS after reading the DNL segment.
+func (d *Decoder) endOfData() error {
+	if d.accEnd != ErrUnexpectedMarker {
+		return d.accEnd
+	}
 
-				return 0, EOS
-			}
+	m, err := d.readMarker()
+	if err != nil {
+		return err
+	}
 
-			return 0, ErrUnexpectedMarker
-		} else if err != nil {
-			return 0, err
+	if m == Marker_DNL {
+		l, err := d.readDNL()
+		if err != nil {
+			return err
 		}
 
-		d.bits = b
-		d.bitMask = 0b1000_0000
-	}
+		d.numLine = l
 
-	bitMask := d.bitMask
-	d.bitMask >>= 1
-	if d.bits&bitMask == 0 {
-		return 0b0, nil
-	} else {
-		return 0b1, nil
+		return EOS
 	}
+
+	return ErrUnexpectedMarker
 }
 
-func (d *Decoder) receive(l int) (uint8, error) {
-	var ret uint8
-	for i := 0; i < l; i++ {
-		b, err := d.nextBit()
-		if err != nil {
-			return 0, err
-		}
+// consume discards the next n bits of the accumulator.
+func (d *Decoder) consume(n uint) {
+	d.acc <<= n
+	d.nacc -= n
+}
+
+func (d *Decoder) nextBit() (uint16, error) {
+	return d.receive(1)
+}
 
-		ret = ret<<1 + uint8(b)
+// resetBits discards the buffered bits of the entropy-coded data.
+func (d *Decoder) resetBits() {
+	d.acc = 0
+	d.nacc = 0
+	d.accEnd = nil
+}
+
+func (d *Decoder) receive(l int) (uint16, error) {
+	if l == 0 {
+		return 0, nil
+	}
+
+	n := uint(l)
+	if d.nacc < n {
+		d.fill()
+		if d.nacc < n {
+			return 0, d.endOfData()
+		}
 	}
 
+	ret := uint16(d.acc >> (64 - n))
+	d.consume(n)
+
 	return ret, nil
 }
@@ -100,3 +100,80 @@ func TestReadBits(t *testing.T) {
 		t.Errorf("bits[2:8] is not 3, actual=%v", v)
 	}
 }
+
+func TestReceive_stuffing(t *testing.T) {
+	d := New(bytes.NewReader([]byte{
+		0xFF, 0x00,
+		0b1010_1111, 0xFF, 0x00,
+		0b0110_0000,
+	}))
+
+	for i, exp := range []uint16{0xFF, 0b1010, 0b1111_1111_1111, 0b0110} {
+		n := 4
+		switch i {
+		case 0:
+			n = 8
+		case 2:
+			n = 12
+		}
+		v, err := d.receive(n)
+		if err != nil {
+			t.Fatalf("receive[%d]: %v", i, err)
+		}
+
+		if v != exp {
+			t.Errorf("receive[%d]=0b%b exp=0b%b", i, v, exp)
+		}
+	}
+}
+
+func TestReceive_marker(t *testing.T) {
+	d := New(bytes.NewReader([]byte{
+		0b1100_0000,
+		0xFF, 0xD0, // RST0
+	}))
+
+	v, err := d.receive(8)
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if v != 0b1100_0000 {
+		t.Errorf("receive=0b%b", v)
+	}
+
+	if _, err := d.nextBit(); err != ErrUnexpectedMarker {
+		t.Errorf("nextBit after the marker: %v", err)
+	}
+}
+
+func TestReceive_DNL(t *testing.T) {
+	d := New(bytes.NewReader([]byte{
+		0b1000_0000,
+		0xFF, 0xDC, 0x00, 0x04, 0x00, 0x2A, // DNL
+	}))
+
+	if _, err := d.receive(3); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+
+	// the marker is not read while the bits of the data remain
+	d.resetBits()
+	m, err := d.readMarker()
+	if err != nil {
+		t.Fatalf("readMarker: %v", err)
+	}
+	if m != Marker_DNL {
+		t.Errorf("marker=%v", m)
+	}
+
+	d = New(bytes.NewReader([]byte{
+		0b1000_0000,
+		0xFF, 0xDC, 0x00, 0x04, 0x00, 0x2A, // DNL
+	}))
+	if _, err := d.receive(9); err != EOS {
+		t.Fatalf("receive over DNL: %v", err)
+	}
+	if d.numLine != 42 {
+		t.Errorf("numLine=%d", d.numLine)
+	}
+}
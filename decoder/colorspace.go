@@ -0,0 +1,88 @@
+package decoder
+
+import (
+	"log/slog"
+)
+
+// colorSpace is the color space of the coded components.
+type colorSpace int
+
+const (
+	colorSpaceUnknown colorSpace = iota
+	colorSpaceGray
+	colorSpaceYCbCr
+	colorSpaceRGB
+	colorSpaceCMYK
+	colorSpaceYCCK
+)
+
+func (cs colorSpace) String() string {
+	switch cs {
+	case colorSpaceGray:
+		return "Gray"
+	case colorSpaceYCbCr:
+		return "YCbCr"
+	case colorSpaceRGB:
+		return "RGB"
+	case colorSpaceCMYK:
+		return "CMYK"
+	case colorSpaceYCCK:
+		return "YCCK"
+	}
+	return "Unknown"
+}
+
+// inferColorSpace infers the color space of the frame from the JFIF and Adobe
+// segments and the component identifiers in the same way as libjpeg.
+// The components are in the order of the frame header.
+func inferColorSpace(h *frameHeader, jfif bool, adobe *adobeSegment) colorSpace {
+	switch h.nf {
+	case 1:
+		return colorSpaceGray
+	case 3:
+		if jfif {
+			return colorSpaceYCbCr
+		}
+
+		if adobe != nil {
+			switch adobe.transform {
+			case adobeTransformNone:
+				return colorSpaceRGB
+			case adobeTransformYCbCr:
+				return colorSpaceYCbCr
+			}
+			slog.Warn("unknown Adobe transform", "transform", adobe.transform)
+			return colorSpaceYCbCr
+		}
+
+		c0, c1, c2 := h.params[0].c, h.params[1].c, h.params[2].c
+		switch {
+		case c0 == 1 && c1 == 2 && c2 == 3:
+			return colorSpaceYCbCr
+		case c0 == 'R' && c1 == 'G' && c2 == 'B':
+			return colorSpaceRGB
+		}
+		slog.Info("unknown component identifiers, assuming YCbCr", "C0", c0, "C1", c1, "C2", c2)
+		return colorSpaceYCbCr
+	case 4:
+		if adobe == nil {
+			return colorSpaceCMYK
+		}
+
+		switch adobe.transform {
+		case adobeTransformNone:
+			return colorSpaceCMYK
+		case adobeTransformYCCK:
+			return colorSpaceYCCK
+		}
+		slog.Warn("unknown Adobe transform", "transform", adobe.transform)
+		return colorSpaceYCCK
+	}
+
+	return colorSpaceUnknown
+}
+
+// colorSpace returns the color space of the frame decoded by d.
+func (d *Decoder) colorSpace(h *frameHeader) colorSpace {
+	return inferColorSpace(h, d.jfif, d.adobe)
+}
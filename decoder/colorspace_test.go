@@ -0,0 +1,124 @@
+package decoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+func TestInferColorSpace(t *testing.T) {
+	header := func(ids ...uint8) *frameHeader {
+		h := &frameHeader{nf: uint8(len(ids))}
+		for _, id := range ids {
+			h.params = append(h.params, &frameComponentParam{c: id})
+		}
+		return h
+	}
+	adobe := func(transform uint8) *adobeSegment {
+		return &adobeSegment{transform: transform}
+	}
+
+	for i, c := range []struct {
+		h     *frameHeader
+		jfif  bool
+		adobe *adobeSegment
+		exp   colorSpace
+	}{
+		{header(5), false, nil, colorSpaceGray},
+		{header(1, 2, 3), false, nil, colorSpaceYCbCr},
+		{header(0, 1, 2), false, nil, colorSpaceYCbCr},
+		{header('R', 'G', 'B'), false, nil, colorSpaceRGB},
+		{header('R', 'G', 'B'), true, nil, colorSpaceYCbCr},
+		{header(1, 2, 3), false, adobe(0), colorSpaceRGB},
+		{header('R', 'G', 'B'), false, adobe(1), colorSpaceYCbCr},
+		{header(1, 2, 3), true, adobe(0), colorSpaceYCbCr},
+		{header(1, 2, 3, 4), false, nil, colorSpaceCMYK},
+		{header(1, 2, 3, 4), false, adobe(0), colorSpaceCMYK},
+		{header(1, 2, 3, 4), false, adobe(2), colorSpaceYCCK},
+		{header(1, 2), false, nil, colorSpaceUnknown},
+	} {
+		if act := inferColorSpace(c.h, c.jfif, c.adobe); act != c.exp {
+			t.Errorf("%d: exp=%v act=%v", i, c.exp, act)
+		}
+	}
+}
+
+func TestDecode_rgb(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		ids  []byte
+	}{
+		{"testdata/rgb.jpg", nil},             // Adobe transform 0
+		{"testdata/rgb.jpg", []byte{0, 1, 2}}, // Adobe transform 0 with zero-based identifiers
+		{"testdata/rgb_ids.jpg", nil},         // identifiers 'R', 'G', 'B' only
+	} {
+		data, err := os.ReadFile(c.name)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+
+		exp, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("jpeg.Decode(%s): %v", c.name, err)
+		}
+
+		if c.ids != nil {
+			sof := bytes.Index(data, []byte{0xFF, byte(Marker_SOF0)})
+			sos := bytes.Index(data, []byte{0xFF, byte(Marker_SOS)})
+			for i, id := range c.ids {
+				data[sof+10+3*i] = id
+				data[sos+5+2*i] = id
+			}
+		}
+
+		act, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Decode(%s): %v", c.name, err)
+		}
+
+		if _, ok := act.(*image.RGBA); !ok {
+			t.Fatalf("%s: unexpected image type: %T", c.name, act)
+		}
+
+		compareImage(t, exp, act, 1)
+
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DecodeConfig(%s): %v", c.name, err)
+		}
+
+		if cfg.ColorModel != color.RGBAModel {
+			t.Errorf("%s: unexpected color model", c.name)
+		}
+	}
+}
+
+func TestDecode_componentOrder(t *testing.T) {
+	// YCbCr with zero-based identifiers is mapped by the order in the frame header
+	data, err := os.ReadFile("testdata/subsample_422.jpg")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	exp, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	sof := bytes.Index(data, []byte{0xFF, byte(Marker_SOF0)})
+	sos := bytes.Index(data, []byte{0xFF, byte(Marker_SOS)})
+	for i := 0; i < 3; i++ {
+		data[sof+10+3*i] = byte(i)
+		data[sos+5+2*i] = byte(i)
+	}
+
+	act, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	compareImage(t, exp, act, 0)
+}
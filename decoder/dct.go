@@ -1,82 +1,44 @@
 package decoder
 
 import (
-	"math"
+	"errors"
 
-	"gonum.org/v1/gonum/mat"
+	"github.com/yunomu/jpeg/jpeg"
 )
 
 var (
-	dctA, dctAT mat.Matrix
+	dct   = jpeg.Dct
+	idct  = jpeg.Idct
+	idct_ = jpeg.IdctMatrix
 )
 
-func init() {
-	var data []float64
-	m0 := 1 / (2 * math.Sqrt(2))
-	for i := 0; i < 8; i++ {
-		data = append(data, m0)
-	}
-
-	for i := 1; i < 8; i++ {
-		for j := 0; j < 8; j++ {
-			data = append(data, 1/2.0*math.Cos(math.Pi*float64((2*j+1)*i)/16.0))
-		}
-	}
-
-	dctA = mat.NewDense(8, 8, data)
-	dctAT = dctA.T()
-}
-
-func toMatInt(s [][]int16) mat.Matrix {
-	var data []float64
-	for _, row := range s {
-		for _, v := range row {
-			data = append(data, float64(v))
-		}
-	}
-	return mat.NewDense(8, 8, data)
-}
-
-func dct(s [][]int16) [][]float64 {
-	x := toMatInt(s)
-
-	var r mat.Dense
-	r.Mul(dctA, x)
-	r.Mul(&r, dctAT)
-
-	var ret [][]float64
-	for i := 0; i < 8; i++ {
-		ret = append(ret, r.RawRowView(i))
-	}
+// DCTMethod is the implementation of the IDCT.
+type DCTMethod = jpeg.DCTMethod
 
-	return ret
-}
-
-func toMat(s [][]float64) mat.Matrix {
-	var data []float64
-	for _, row := range s {
-		data = append(data, row...)
-	}
-	return mat.NewDense(8, 8, data)
-}
-
-func idct(b [][]float64) [][]float64 {
-	x := toMat(b)
-
-	r := idct_(x)
+const (
+	DCTISlow  = jpeg.DCTISlow
+	DCTIFast  = jpeg.DCTIFast
+	DCTFloat  = jpeg.DCTFloat
+	DCTMatrix = jpeg.DCTMatrix
+)
 
-	var ret [][]float64
-	for i := 0; i < 8; i++ {
-		ret = append(ret, r.RawRowView(i))
+var ErrInvalidDCTMethod = errors.New("invalid DCT method")
+
+// idctFunc transforms the coefficients in natural order dequantized by the
+// multipliers into the samples of the precision p, which are level shifted and
+// clamped unless the frame is differential.
+type idctFunc func(in *block, t *idctTable, out *block, p uint8, differential bool)
+
+func idctMethod(m DCTMethod) (idctFunc, error) {
+	switch m {
+	case DCTISlow:
+		return idctIslow, nil
+	case DCTIFast:
+		return idctIFast, nil
+	case DCTFloat:
+		return idctFloat, nil
+	case DCTMatrix:
+		return idctMatrix, nil
 	}
-
-	return ret
-}
-
-func idct_(b mat.Matrix) *mat.Dense {
-	var r mat.Dense
-	r.Mul(dctAT, b)
-	r.Mul(&r, dctA)
-
-	return &r
+	return nil, ErrInvalidDCTMethod
 }
@@ -679,7 +679,7 @@ func matRound(m mat.Matrix) *mat.Dense {
 
 func TestIDCT_Value0(t *testing.T) {
 	raw := mat.NewDense(8, 8, []float64{328, 0, 10, 0, 0, 0, 0, 0, 18, 0, 0, 0, 0, 0, 0, 0, -7, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0})
-	exp := mat.NewDense(8, 8, []float64{45, 44, 42, 41, 41, 42, 44, 45, 45, 44, 43, 42, 42, 43, 44, 45, 45, 44, 43, 42, 42, 43, 44, 45, 44, 43, 42, 41, 41, 42, 43, 44, 43, 42, 41, 40, 40, 41, 42, 43, 41, 40, 39, 38, 38, 39, 40, 41, 40, 39, 37, 36, 36, 37, 39, 40, 38, 37, 36, 35, 35, 36, 37, 38})
+	exp := mat.NewDense(8, 8, []float64{45, 44, 42, 41, 41, 42, 44, 45, 45, 44, 42, 42, 42, 42, 44, 45, 45, 44, 43, 42, 42, 43, 44, 45, 44, 43, 42, 41, 41, 42, 43, 44, 43, 42, 41, 40, 40, 41, 42, 43, 41, 40, 39, 38, 38, 39, 40, 41, 40, 39, 37, 36, 36, 37, 39, 40, 38, 37, 36, 35, 35, 36, 37, 38})
 
 	r := idct_(raw)
 	act := matRound(r)
@@ -3,10 +3,9 @@ package decoder
 import (
 	"bufio"
 	"errors"
-	"image/jpeg"
+	"image"
 	"io"
 	"log/slog"
-	"os"
 )
 
 type Decoder struct {
@@ -16,17 +15,56 @@ type Decoder struct {
 	prevMarker Marker
 	unreaded   bool
 
-	bitMask uint8
-	bits    uint8
+	acc     uint64 // bits of the entropy-coded data from the MSB
+	nacc    uint   // number of the bits in acc
+	accEnd  error  // error which ended the entropy-coded data
 	numLine uint16
 
-	pred map[uint8]int16
+	pred         map[uint8]int32
+	eobrun       int
+	progressive  bool
+	arithmetic   bool
+	differential bool
+	arith        arithDecoder
+	hierarchical bool
+
+	mcuDone func(n int) // called after the MCU n is decoded
+
+	jfif  bool
+	adobe *adobeSegment
+
+	opts Options
+}
+
+// Options are the decoding parameters. The zero value decodes with DCTISlow.
+type Options struct {
+	// DCTMethod is the implementation of the IDCT.
+	DCTMethod DCTMethod
+
+	// RestartWorkers is the number of the goroutines decoding the restart
+	// intervals of a scan in parallel. 0 or 1 decodes them sequentially.
+	RestartWorkers int
+
+	// PipelineWorkers is the number of the goroutines which reconstruct the
+	// samples of the MCU rows and convert them into the image, while a scan of
+	// all components of a sequential frame is entropy decoded. 0 or 1 disables
+	// the pipeline.
+	PipelineWorkers int
 }
 
 func New(r io.Reader) *Decoder {
-	return &Decoder{
+	return NewWithOptions(r, nil)
+}
+
+// NewWithOptions returns a Decoder with the options. nil means the zero value.
+func NewWithOptions(r io.Reader, opts *Options) *Decoder {
+	d := &Decoder{
 		r: bufio.NewReader(r),
 	}
+	if opts != nil {
+		d.opts = *opts
+	}
+	return d
 }
 
 var (
@@ -58,6 +96,14 @@ func (d *Decoder) readByteMarker() (byte, Marker, error) {
 		return 0, 0, err
 	}
 
+	// skip fill bytes
+	for m == Marker_Prefix {
+		m, err = d.r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
 	if m == Marker_FF {
 		d.prevByte = b
 		d.prevMarker = 0
@@ -86,6 +132,25 @@ func (d *Decoder) readByte() (byte, error) {
 	return b, nil
 }
 
+// readRawByte reads a byte in a marker segment, where 0xFF is not a marker prefix.
+func (d *Decoder) readRawByte() (byte, error) {
+	if d.unreaded {
+		b, m, err := d.readByteMarker()
+		if err != nil {
+			return 0, err
+		}
+
+		if m != 0 {
+			d.unread()
+			return 0, ErrUnexpectedMarker
+		}
+
+		return b, nil
+	}
+
+	return d.r.ReadByte()
+}
+
 func (d *Decoder) readMarker() (Marker, error) {
 	b, m, err := d.readByteMarker()
 	if err != nil {
@@ -107,7 +172,7 @@ func (d *Decoder) readBytes(n int) ([]byte, error) {
 	var ret []byte
 
 	for i := 0; i < n; i++ {
-		b, err := d.readByte()
+		b, err := d.readRawByte()
 		if err != nil {
 			return nil, err
 		}
@@ -132,7 +197,7 @@ func (d *Decoder) readUint16() (uint16, error) {
 }
 
 func (d *Decoder) readUint8() (uint8, error) {
-	b, err := d.readByte()
+	b, err := d.readRawByte()
 	if err != nil {
 		return 0, err
 	}
@@ -156,6 +221,7 @@ func (d *Decoder) readDRI() (uint16, error) {
 type miscTables struct {
 	hufftables         []*hufftable
 	quantizationTables []*quantizationTable
+	conditioningTables []*conditioningTable
 	interval           int
 }
 
@@ -163,14 +229,18 @@ func (t *miscTables) cascade(t1 *miscTables) *miscTables {
 	ret := &miscTables{
 		hufftables:         t.hufftables,
 		quantizationTables: t.quantizationTables,
+		conditioningTables: t.conditioningTables,
 		interval:           t.interval,
 	}
 
-	if t1.hufftables != nil {
-		ret.hufftables = t1.hufftables
+	for _, ht := range t1.hufftables {
+		ret.hufftables = replaceHufftable(ret.hufftables, ht)
+	}
+	for _, qt := range t1.quantizationTables {
+		ret.quantizationTables = replaceQuantizationTable(ret.quantizationTables, qt)
 	}
-	if t1.quantizationTables != nil {
-		ret.quantizationTables = t1.quantizationTables
+	for _, ct := range t1.conditioningTables {
+		ret.conditioningTables = replaceConditioningTable(ret.conditioningTables, ct)
 	}
 	if t1.interval != -1 {
 		ret.interval = t1.interval
@@ -194,6 +264,13 @@ func (d *Decoder) decodeMisc() (*miscTables, error) {
 			return &ret, nil
 		}
 
+		if m.isAPP() {
+			if err := d.readAPP(m); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
 		switch m {
 		case Marker_DQT:
 			qts, err := d.readDQT()
@@ -201,14 +278,18 @@ func (d *Decoder) decodeMisc() (*miscTables, error) {
 				return nil, err
 			}
 			slog.Debug("DQT", "tables", qts)
-			ret.quantizationTables = append(ret.quantizationTables, qts...)
+			for _, qt := range qts {
+				ret.quantizationTables = replaceQuantizationTable(ret.quantizationTables, qt)
+			}
 
 		case Marker_DHT:
 			hts, err := d.readDHT()
 			if err != nil {
 				return nil, err
 			}
-			ret.hufftables = append(ret.hufftables, hts...)
+			for _, ht := range hts {
+				ret.hufftables = replaceHufftable(ret.hufftables, ht)
+			}
 
 		case Marker_DRI:
 			ri, err := d.readDRI()
@@ -217,7 +298,16 @@ func (d *Decoder) decodeMisc() (*miscTables, error) {
 			}
 			ret.interval = int(ri)
 
-		case Marker_DAC, Marker_COM, Marker_APP_n:
+		case Marker_DAC:
+			cts, err := d.readDAC()
+			if err != nil {
+				return nil, err
+			}
+			for _, ct := range cts {
+				ret.conditioningTables = replaceConditioningTable(ret.conditioningTables, ct)
+			}
+
+		case Marker_COM:
 			l, err := d.readUint16()
 			if err != nil {
 				return nil, err
@@ -240,17 +330,32 @@ func (d *Decoder) decodeMisc() (*miscTables, error) {
 	}
 }
 
-func (d *Decoder) decodeFrame(misc *miscTables) (*frameHeader, map[uint8][]block, error) {
+// decodeFrame decodes the frame and its scans. It returns the tables updated in the frame.
+func (d *Decoder) decodeFrame(misc *miscTables) (*frameHeader, *miscTables, error) {
 	header, err := d.readFrameHeader()
 	if err != nil {
 		return nil, nil, err
 	}
 
+	if header.y == 0 {
+		return nil, nil, errors.New("number of lines defined by DNL is not supported")
+	}
+
+	for _, p := range header.params {
+		if header.isLossless() {
+			p.stride = p.bw
+			p.pix = make([]uint16, p.bw*p.bh)
+		} else {
+			p.coefs = make([]block, p.bw*p.bh)
+		}
+	}
+
 	for {
 		misc1, err := d.decodeMisc()
 		if err != nil {
 			return nil, nil, err
 		}
+		misc = misc.cascade(misc1)
 
 		m, err := d.readMarker()
 		if err != nil {
@@ -258,24 +363,87 @@ func (d *Decoder) decodeFrame(misc *miscTables) (*frameHeader, map[uint8][]block
 		}
 
 		if m != Marker_SOS {
-			slog.Error("marker is not start of scan", "marker", m)
-			return nil, nil, ErrUnexpectedMarker
+			// end of the frame
+			d.unread()
+			return header, misc, nil
 		}
 
-		cs, err := d.decodeScan(header, misc.cascade(misc1))
-		if err != nil {
+		if err := d.decodeScan(header, misc); err != nil {
 			return nil, nil, err
 		}
+	}
+}
 
-		if err := d.readEOI(); err == ErrUnexpectedMarker {
-			d.unread()
-			continue
-		} else if err != nil {
-			return nil, nil, err
+// decodeFrames decodes the frames of the stream and reconstructs their samples.
+// It stops after n frames if n > 0. Only a hierarchical stream has multiple frames.
+func (d *Decoder) decodeFrames(n int) ([]*frameHeader, error) {
+	if _, err := idctMethod(d.opts.DCTMethod); err != nil {
+		return nil, err
+	}
+
+	if err := d.readSOI(); err != nil {
+		return nil, err
+	}
+
+	misc := &miscTables{interval: -1}
+	var dhp *frameHeader
+	var ref []*frameComponentParam
+	var ret []*frameHeader
+	for n <= 0 || len(ret) < n {
+		misc1, err := d.decodeMisc()
+		if err != nil {
+			return nil, err
 		}
+		misc = misc.cascade(misc1)
 
-		return header, cs, nil
+		m, err := d.readMarker()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case m == Marker_DHP && dhp == nil && len(ret) == 0:
+			dhp, err = d.readFrameParams(m)
+			if err != nil {
+				return nil, err
+			}
+			d.hierarchical = true
+
+		case m == Marker_EXP && dhp != nil && len(ret) != 0:
+			eh, ev, err := d.readEXP()
+			if err != nil {
+				return nil, err
+			}
+			for i, p := range ref {
+				ref[i] = p.expand(eh, ev)
+			}
+
+		case m.isFrameMarker() && (dhp != nil || len(ret) == 0):
+			d.unread()
+			hdr, misc1, err := d.decodeFrame(misc)
+			if err != nil {
+				return nil, err
+			}
+			misc = misc1
+
+			if hdr.img == nil {
+				if err := reconstructFrame(hdr, ref, d.opts.DCTMethod); err != nil {
+					return nil, err
+				}
+			}
+			ref = append([]*frameComponentParam(nil), hdr.params...)
+			ret = append(ret, hdr)
+
+		case m == Marker_EOI && len(ret) != 0:
+			return ret, nil
+
+		default:
+			slog.Error("unexpected marker", "marker", m)
+			return nil, ErrUnexpectedMarker
+		}
 	}
+
+	return ret, nil
 }
 
 func (d *Decoder) readSOI() error {
@@ -306,21 +474,90 @@ func (d *Decoder) readEOI() error {
 	return nil
 }
 
-func (d *Decoder) Decode() error {
+// Decode reads the whole JPEG stream and returns the decoded image.
+// The image of a hierarchical stream is that of the last frame.
+func (d *Decoder) Decode() (image.Image, error) {
+	hdrs, err := d.decodeFrames(0)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.makeImage(hdrs[len(hdrs)-1])
+}
+
+// DecodeLevels returns the images of the resolution levels of a hierarchical
+// stream from the lowest one, and stops decoding after n levels if n > 0.
+// Other streams have a single level.
+func (d *Decoder) DecodeLevels(n int) ([]image.Image, error) {
+	hdrs, err := d.decodeFrames(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []image.Image
+	for _, hdr := range hdrs {
+		img, err := d.makeImage(hdr)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, img)
+	}
+
+	return ret, nil
+}
+
+// DecodeConfig reads the stream up to the frame header and returns
+// the color model and dimensions of the image without decoding it.
+func (d *Decoder) DecodeConfig() (image.Config, error) {
 	if err := d.readSOI(); err != nil {
-		return err
+		return image.Config{}, err
 	}
 
-	misc, err := d.decodeMisc()
+	if _, err := d.decodeMisc(); err != nil {
+		return image.Config{}, err
+	}
+
+	m, err := d.readMarker()
 	if err != nil {
-		return err
+		return image.Config{}, err
 	}
 
-	hdr, cs, err := d.decodeFrame(misc)
+	var hdr *frameHeader
+	if m == Marker_DHP {
+		// size of the last frame of the hierarchical stream
+		hdr, err = d.readFrameParams(m)
+	} else {
+		d.unread()
+		hdr, err = d.readFrameHeader()
+	}
 	if err != nil {
-		return err
+		return image.Config{}, err
+	}
+
+	cm, err := colorModel(hdr, d.colorSpace(hdr))
+	if err != nil {
+		return image.Config{}, err
 	}
 
-	img := makeImage_(hdr, cs)
-	return jpeg.Encode(os.Stdout, img, nil)
+	return image.Config{
+		ColorModel: cm,
+		Width:      int(hdr.x),
+		Height:     int(hdr.y),
+	}, nil
+}
+
+// Decode reads a JPEG image from r and returns it as an image.Image.
+func Decode(r io.Reader) (image.Image, error) {
+	return New(r).Decode()
+}
+
+// DecodeLevels reads a JPEG image from r and returns the images of its resolution levels.
+func DecodeLevels(r io.Reader, n int) ([]image.Image, error) {
+	return New(r).DecodeLevels(n)
+}
+
+// DecodeConfig returns the color model and dimensions of a JPEG image
+// without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	return New(r).DecodeConfig()
 }
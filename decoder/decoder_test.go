@@ -0,0 +1,122 @@
+package decoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testImage(w, h int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 5), uint8(x + y), 0xFF})
+		}
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("jpeg.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func diff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func compareImage(t *testing.T, exp, act image.Image, tolerance uint32) {
+	t.Helper()
+
+	if exp.Bounds() != act.Bounds() {
+		t.Fatalf("bounds mismatch: exp=%v act=%v", exp.Bounds(), act.Bounds())
+	}
+
+	b := exp.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			er, eg, eb, ea := exp.At(x, y).RGBA()
+			ar, ag, ab, aa := act.At(x, y).RGBA()
+			if diff(er>>8, ar>>8) > tolerance ||
+				diff(eg>>8, ag>>8) > tolerance ||
+				diff(eb>>8, ab>>8) > tolerance ||
+				diff(ea>>8, aa>>8) > tolerance {
+				t.Fatalf("pixel mismatch at (%d, %d): exp=%v act=%v", x, y, exp.At(x, y), act.At(x, y))
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	data := encodeTestImage(t, testImage(64, 48))
+
+	exp, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("jpeg.Decode: %v", err)
+	}
+
+	act, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	compareImage(t, exp, act, 4)
+}
+
+func TestDecodeConfig(t *testing.T) {
+	data := encodeTestImage(t, testImage(64, 48))
+
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeConfig: %v", err)
+	}
+
+	if cfg.Width != 64 || cfg.Height != 48 {
+		t.Errorf("size mismatch: exp=(64, 48) act=(%d, %d)", cfg.Width, cfg.Height)
+	}
+	if cfg.ColorModel != color.YCbCrModel {
+		t.Errorf("color model mismatch: %v", cfg.ColorModel)
+	}
+}
+
+func TestDecodeConfig_testdata(t *testing.T) {
+	names, err := filepath.Glob("testdata/*.jpg")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+
+	// DecodeConfig fails as Decode, and reports the model of the decoded image
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+
+		img, decErr := Decode(bytes.NewReader(data))
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		if err != decErr {
+			t.Errorf("%s: DecodeConfig err=%v Decode err=%v", name, err, decErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if cfg.ColorModel != img.ColorModel() {
+			t.Errorf("%s: color model mismatch: %v", name, cfg.ColorModel)
+		}
+		if b := img.Bounds(); cfg.Width != b.Dx() || cfg.Height != b.Dy() {
+			t.Errorf("%s: size mismatch: (%d, %d)", name, cfg.Width, cfg.Height)
+		}
+	}
+}
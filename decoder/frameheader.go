@@ -1,17 +1,34 @@
 package decoder
 
 import (
+	"errors"
 	"fmt"
+	"image"
 	"log/slog"
 	"math"
 )
 
+var (
+	ErrInvalidSamplingFactor = errors.New("invalid sampling factor")
+	ErrUnsupportedPrecision  = errors.New("unsupported sample precision")
+)
+
 type frameComponentParam struct {
 	c    uint8
 	h, v uint8
 	tq   uint8
 
 	x, y uint16
+
+	bw, bh int                // number of data units per line and column, padded to the MCU
+	coefs  []block            // coefficients in zigzag order, bw*bh blocks
+	qt     *quantizationTable // latched at the first scan of the component
+	pix    []uint16           // reconstructed samples
+	stride int                // number of samples per line of pix
+}
+
+func (p *frameComponentParam) block(x, y int) *block {
+	return &p.coefs[y*p.bw+x]
 }
 
 func (p *frameComponentParam) String() string {
@@ -24,13 +41,78 @@ type frameHeader struct {
 	y, x       uint16
 	nf         uint8
 	hMax, vMax uint8
+	mcuX, mcuY int
 	params     []*frameComponentParam
+
+	img image.Image // image made by the pipeline while decoding the frame
+}
+
+func (h *frameHeader) isProgressive() bool {
+	switch h.marker {
+	case Marker_SOF2, Marker_SOF6, Marker_SOF10, Marker_SOF14:
+		return true
+	}
+	return false
+}
+
+func (m Marker) isLossless() bool {
+	switch m {
+	case Marker_SOF3, Marker_SOF7, Marker_SOF11, Marker_SOF15:
+		return true
+	}
+	return false
+}
+
+// isDifferential reports whether the frame is a differential frame of the hierarchical mode.
+func (h *frameHeader) isDifferential() bool {
+	switch h.marker {
+	case Marker_SOF5, Marker_SOF6, Marker_SOF7, Marker_SOF13, Marker_SOF14, Marker_SOF15:
+		return true
+	}
+	return false
+}
+
+// isArithmetic reports whether the frame uses arithmetic coding.
+func (h *frameHeader) isArithmetic() bool {
+	return h.marker >= Marker_SOF9 && h.marker <= Marker_SOF15
+}
+
+func (h *frameHeader) isLossless() bool {
+	return h.marker.isLossless()
+}
+
+// unitSize returns the width and height of a data unit in samples.
+func (h *frameHeader) unitSize() int {
+	if h.isLossless() {
+		return 1
+	}
+	return 8
 }
 
 func (h *frameHeader) String() string {
 	return fmt.Sprintf("(marker=%v p=%d (x, y)=(%d, %d) Nf=%d params=%v)", h.marker, h.p, h.x, h.y, h.nf, h.params)
 }
 
+func validatePrecision(m Marker, p uint8) error {
+	switch {
+	case m == Marker_SOF0:
+		if p == 8 {
+			return nil
+		}
+	case m.isLossless(), m == Marker_DHP:
+		if 2 <= p && p <= 16 {
+			return nil
+		}
+	default:
+		if p == 8 || p == 12 {
+			return nil
+		}
+	}
+
+	slog.Error("unsupported sample precision", "marker", m, "P", p)
+	return ErrUnsupportedPrecision
+}
+
 func (d *Decoder) readFrameHeader() (*frameHeader, error) {
 	m, err := d.readMarker()
 	if err != nil {
@@ -42,6 +124,11 @@ func (d *Decoder) readFrameHeader() (*frameHeader, error) {
 		return nil, ErrUnexpectedMarker
 	}
 
+	return d.readFrameParams(m)
+}
+
+// readFrameParams reads the parameters of a frame header or a DHP segment.
+func (d *Decoder) readFrameParams(m Marker) (*frameHeader, error) {
 	lf, err := d.readUint16()
 	if err != nil {
 		return nil, err
@@ -52,6 +139,10 @@ func (d *Decoder) readFrameHeader() (*frameHeader, error) {
 		return nil, err
 	}
 
+	if err := validatePrecision(m, p); err != nil {
+		return nil, err
+	}
+
 	y, err := d.readUint16()
 	if err != nil {
 		return nil, err
@@ -67,6 +158,10 @@ func (d *Decoder) readFrameHeader() (*frameHeader, error) {
 		return nil, err
 	}
 
+	if nf == 0 {
+		return nil, errors.New("no component in frame")
+	}
+
 	var params []*frameComponentParam
 	var hmax, vmax uint8
 	for i := 0; i < int(nf); i++ {
@@ -81,6 +176,9 @@ func (d *Decoder) readFrameHeader() (*frameHeader, error) {
 		}
 		h := hv >> 4
 		v := hv & 0xF
+		if h == 0 || h > 4 || v == 0 || v > 4 {
+			return nil, ErrInvalidSamplingFactor
+		}
 
 		hmax = max(h, hmax)
 		vmax = max(v, vmax)
@@ -97,9 +195,17 @@ func (d *Decoder) readFrameHeader() (*frameHeader, error) {
 			tq: tq,
 		})
 	}
+	unit := 8
+	if m.isLossless() {
+		unit = 1
+	}
+	mcux := padding(unit*int(hmax), int(x)) / (unit * int(hmax))
+	mcuy := padding(unit*int(vmax), int(y)) / (unit * int(vmax))
 	for _, p := range params {
 		p.x = uint16(math.Ceil(float64(x) * float64(p.h) / float64(hmax)))
 		p.y = uint16(math.Ceil(float64(y) * float64(p.v) / float64(vmax)))
+		p.bw = mcux * int(p.h)
+		p.bh = mcuy * int(p.v)
 	}
 
 	ret := &frameHeader{
@@ -110,6 +216,8 @@ func (d *Decoder) readFrameHeader() (*frameHeader, error) {
 		nf:     nf,
 		hMax:   hmax,
 		vMax:   vmax,
+		mcuX:   mcux,
+		mcuY:   mcuy,
 		params: params,
 	}
 
@@ -0,0 +1,136 @@
+package decoder
+
+import (
+	"errors"
+	"log/slog"
+)
+
+var (
+	ErrInvalidExpansion = errors.New("invalid EXP segment")
+	ErrMissingReference = errors.New("reference of differential frame not found")
+)
+
+// readEXP reads whether the reference components are expanded horizontally and vertically.
+func (d *Decoder) readEXP() (bool, bool, error) {
+	le, err := d.readUint16()
+	if err != nil {
+		return false, false, err
+	}
+
+	if le != 3 {
+		return false, false, ErrInvalidExpansion
+	}
+
+	e, err := d.readUint8()
+	if err != nil {
+		return false, false, err
+	}
+	eh := e >> 4
+	ev := e & 0x0F
+	if eh > 1 || ev > 1 {
+		return false, false, ErrInvalidExpansion
+	}
+
+	slog.Info("expand reference",
+		"Eh", eh,
+		"Ev", ev,
+	)
+
+	return eh == 1, ev == 1, nil
+}
+
+// expand upsamples the reference component by a factor of two horizontally
+// and/or vertically with the bi-linear interpolation of J.1.1.2.
+// The last sample of each line and column is replicated.
+func (p *frameComponentParam) expand(eh, ev bool) *frameComponentParam {
+	ret := &frameComponentParam{
+		c:      p.c,
+		h:      p.h,
+		v:      p.v,
+		x:      p.x,
+		y:      p.y,
+		pix:    p.pix,
+		stride: p.stride,
+	}
+
+	if eh {
+		w := int(ret.x) * 2
+		pix := make([]uint16, w*int(ret.y))
+		for y := 0; y < int(ret.y); y++ {
+			src := ret.pix[y*ret.stride:]
+			for x := 0; x < int(ret.x); x++ {
+				a, b := src[x], src[min(x+1, int(ret.x)-1)]
+				pix[y*w+2*x] = a
+				pix[y*w+2*x+1] = uint16((uint32(a) + uint32(b)) >> 1)
+			}
+		}
+		ret.x, ret.pix, ret.stride = uint16(w), pix, w
+	}
+
+	if ev {
+		h := int(ret.y) * 2
+		pix := make([]uint16, int(ret.x)*h)
+		for y := 0; y < int(ret.y); y++ {
+			a := ret.pix[y*ret.stride:]
+			b := ret.pix[min(y+1, int(ret.y)-1)*ret.stride:]
+			for x := 0; x < int(ret.x); x++ {
+				pix[2*y*int(ret.x)+x] = a[x]
+				pix[(2*y+1)*int(ret.x)+x] = uint16((uint32(a[x]) + uint32(b[x])) >> 1)
+			}
+		}
+		ret.y, ret.pix, ret.stride = uint16(h), pix, int(ret.x)
+	}
+
+	return ret
+}
+
+// addReference adds the reference component to the differences of a differential
+// frame (J.2.3). The sum is taken modulo 2^16 in lossless frames, and clamped to
+// the sample precision in DCT-based frames.
+func (p *frameComponentParam) addReference(ref *frameComponentParam, prec uint8, lossless bool) {
+	maxv := int64(1)<<prec - 1
+	for y := 0; y < int(p.y); y++ {
+		rl := ref.pix[min(y, int(ref.y)-1)*ref.stride:]
+		for x := 0; x < int(p.x); x++ {
+			r := rl[min(x, int(ref.x)-1)]
+			i := y*p.stride + x
+			if lossless {
+				p.pix[i] += r
+			} else {
+				p.pix[i] = uint16(clamp(int64(r)+int64(int16(p.pix[i])), maxv))
+			}
+		}
+	}
+}
+
+// reconstructFrame reconstructs the samples of a decoded frame with the IDCT of the method.
+// The reference components ref are added to the differences of a differential frame.
+func reconstructFrame(h *frameHeader, ref []*frameComponentParam, m DCTMethod) error {
+	if h.isDifferential() != (len(ref) != 0) {
+		slog.Error("unexpected frame in hierarchical mode", "marker", h.marker)
+		return ErrMissingReference
+	}
+
+	if !h.isLossless() {
+		for _, p := range h.params {
+			if err := p.reconstruct(h.p, h.isDifferential(), m); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !h.isDifferential() {
+		return nil
+	}
+
+	for _, p := range h.params {
+		r := findFrameComponentParam(ref, p.c)
+		if r == nil {
+			return ErrMissingReference
+		}
+
+		p.addReference(r, h.p, h.isLossless())
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package decoder
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+)
+
+func decodeFramesFile(t *testing.T, name string, n int) []*frameHeader {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	hdrs, err := New(bytes.NewReader(data)).decodeFrames(n)
+	if err != nil {
+		t.Fatalf("decodeFrames: %v", err)
+	}
+
+	return hdrs
+}
+
+func TestDecode_hierarchicalLossless(t *testing.T) {
+	// 12-bit, 2 components, lossless frame of 5x4 and differential frames of 10x7 and 19x13
+	hdrs := decodeFramesFile(t, "testdata/hierarchical_lossless.jpg", 0)
+
+	if len(hdrs) != 3 {
+		t.Fatalf("number of frames=%d", len(hdrs))
+	}
+
+	for i, h := range hdrs {
+		l := 2 - i
+		for ci, fp := range h.params {
+			for y := 0; y < int(fp.y); y++ {
+				for x := 0; x < int(fp.x); x++ {
+					exp := losslessSample(ci, x<<l, y<<l, 12, 0)
+					if act := fp.pix[y*fp.stride+x]; act != exp {
+						t.Fatalf("frame %d component %d (%d, %d): exp=%d act=%d", i, ci, x, y, exp, act)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecode_hierarchical(t *testing.T) {
+	// 34x23 extended sequential frame, and 67x45 differential frame whose
+	// blocks have the same DC difference of 6
+	hdrs := decodeFramesFile(t, "testdata/hierarchical.jpg", 0)
+
+	if len(hdrs) != 2 {
+		t.Fatalf("number of frames=%d", len(hdrs))
+	}
+
+	for ci, fp := range hdrs[1].params {
+		ref := hdrs[0].params[ci].expand(true, true)
+		for y := 0; y < int(fp.y); y++ {
+			for x := 0; x < int(fp.x); x++ {
+				exp := int(min(ref.pix[y*ref.stride+x]+6, 255))
+				act := int(fp.pix[y*fp.stride+x])
+				if act < exp-1 || act > exp {
+					t.Fatalf("component %d (%d, %d): exp=%d act=%d", ci, x, y, exp, act)
+				}
+			}
+		}
+	}
+}
+
+func TestDecodeLevels(t *testing.T) {
+	data, err := os.ReadFile("testdata/hierarchical.jpg")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	for _, c := range []struct {
+		n   int
+		exp []image.Rectangle
+	}{
+		{0, []image.Rectangle{image.Rect(0, 0, 34, 23), image.Rect(0, 0, 67, 45)}},
+		{1, []image.Rectangle{image.Rect(0, 0, 34, 23)}},
+		{2, []image.Rectangle{image.Rect(0, 0, 34, 23), image.Rect(0, 0, 67, 45)}},
+	} {
+		imgs, err := DecodeLevels(bytes.NewReader(data), c.n)
+		if err != nil {
+			t.Fatalf("DecodeLevels(%d): %v", c.n, err)
+		}
+
+		if len(imgs) != len(c.exp) {
+			t.Fatalf("DecodeLevels(%d): number of levels=%d", c.n, len(imgs))
+		}
+
+		for i, img := range imgs {
+			if b := img.Bounds(); b != c.exp[i] {
+				t.Errorf("DecodeLevels(%d): level %d bounds=%v exp=%v", c.n, i, b, c.exp[i])
+			}
+		}
+	}
+
+	cfg, err := DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeConfig: %v", err)
+	}
+
+	if cfg.Width != 67 || cfg.Height != 45 {
+		t.Errorf("DecodeConfig: %dx%d", cfg.Width, cfg.Height)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	p := &frameComponentParam{
+		x:      3,
+		y:      2,
+		stride: 4,
+		pix: []uint16{
+			10, 20, 31, 0,
+			30, 40, 50, 0,
+		},
+	}
+
+	r := p.expand(true, true)
+	if r.x != 6 || r.y != 4 {
+		t.Fatalf("size=%dx%d", r.x, r.y)
+	}
+
+	exp := []uint16{
+		10, 15, 20, 25, 31, 31,
+		20, 25, 30, 35, 40, 40,
+		30, 35, 40, 45, 50, 50,
+		30, 35, 40, 45, 50, 50,
+	}
+	for i, v := range exp {
+		if act := r.pix[(i/6)*r.stride+i%6]; act != v {
+			t.Errorf("(%d, %d): exp=%d act=%d", i%6, i/6, v, act)
+		}
+	}
+}
@@ -26,42 +26,55 @@ func (c *huffcode) String() string {
 	return fmt.Sprintf(format, c.value, c.code)
 }
 
+// lookupBits is the number of the bits indexing the lookup table of hufftable.
+const lookupBits = 9
+
 type hufftable struct {
 	class     uint8
 	target    uint8
 	huffcodes []*huffcode
 
-	maxcode map[int]int
-	mincode map[int]int
-	valptr  map[int]int
+	// lookup is indexed by the next lookupBits bits, and has the size of the code
+	// in the upper byte and the value in the lower byte, or 0 for the longer codes.
+	lookup [1 << lookupBits]uint16
+
+	// maxcode[l] is the largest code of the length l+1, or -1 if there is none,
+	// and the value of a code of the length is huffval[code+valoff[l]].
+	maxcode []int32
+	valoff  []int32
+	huffval []uint8
 }
 
 func (t *hufftable) String() string {
 	return fmt.Sprintf("(class=%v target=%v huffcodes=%v)", t.class, t.target, t.huffcodes)
 }
 
-func makeDecoderTables(bits [16]uint8, huffcodes []*huffcode) (map[int]int, map[int]int, map[int]int) {
-	maxcodes := make(map[int]int)
-	mincodes := make(map[int]int)
-	valptr := make(map[int]int)
+func makeDecoderTables(bits [16]uint8, huffcodes []*huffcode) ([]int32, []int32, []uint8) {
+	maxcode := make([]int32, 16)
+	valoff := make([]int32, 16)
+	huffval := make([]uint8, len(huffcodes))
+	for i, c := range huffcodes {
+		huffval[i] = c.value
+	}
+
 	var j int
 	for i := 0; i < 16; i++ {
 		if bits[i] == 0 {
-			maxcodes[i+1] = -1
+			maxcode[i] = -1
 			continue
 		}
 
-		valptr[i+1] = j
-		mincodes[i+1] = int(huffcodes[j].code)
-		j += int(bits[i]) - 1
-		maxcodes[i+1] = int(huffcodes[j].code)
-		j++
+		valoff[i] = int32(j) - int32(huffcodes[j].code)
+		j += int(bits[i])
+		maxcode[i] = int32(huffcodes[j-1].code)
 	}
 
-	return maxcodes, mincodes, valptr
+	return maxcode, valoff, huffval
 }
 
-func makeHufftable(class, target uint8, bits [16]uint8, huffval []*huffval) *hufftable {
+var ErrInvalidHufftable = errors.New("invalid huffman table")
+
+func makeHufftable(class, target uint8, bits [16]uint8, huffval []*huffval) (*hufftable, error) {
 	var huffcodes []*huffcode
 
 	// HUFFSIZE
@@ -73,8 +86,12 @@ func makeHufftable(class, target uint8, bits [16]uint8, huffval []*huffval) *huf
 		}
 	}
 
+	if len(huffcodes) == 0 {
+		return nil, ErrInvalidHufftable
+	}
+
 	// HUFFCODE
-	var code uint16
+	var code uint32
 	prev := huffcodes[0]
 	for _, huffcode := range huffcodes[1:] {
 		code++
@@ -82,7 +99,10 @@ func makeHufftable(class, target uint8, bits [16]uint8, huffval []*huffval) *huf
 		if prev.size != size {
 			code <<= size - prev.size
 		}
-		huffcode.code = code
+		if code >= 1<<size {
+			return nil, ErrInvalidHufftable
+		}
+		huffcode.code = uint16(code)
 		prev = huffcode
 	}
 
@@ -91,16 +111,35 @@ func makeHufftable(class, target uint8, bits [16]uint8, huffval []*huffval) *huf
 		huffcodes[i].value = v.v
 	}
 
-	maxcode, mincode, valptr := makeDecoderTables(bits, huffcodes)
-
-	return &hufftable{
+	t := &hufftable{
 		class:     class,
 		target:    target,
 		huffcodes: huffcodes,
-		maxcode:   maxcode,
-		mincode:   mincode,
-		valptr:    valptr,
 	}
+	t.maxcode, t.valoff, t.huffval = makeDecoderTables(bits, huffcodes)
+
+	for _, c := range huffcodes {
+		if c.size > lookupBits {
+			break
+		}
+		shift := lookupBits - c.size
+		e := uint16(c.size)<<8 | uint16(c.value)
+		for i := int(c.code) << shift; i < int(c.code+1)<<shift; i++ {
+			t.lookup[i] = e
+		}
+	}
+
+	return t, nil
+}
+
+func replaceHufftable(tables []*hufftable, t *hufftable) []*hufftable {
+	ret := []*hufftable{t}
+	for _, t1 := range tables {
+		if t1.class != t.class || t1.target != t.target {
+			ret = append(ret, t1)
+		}
+	}
+	return ret
 }
 
 func (d *Decoder) readHTn() (*hufftable, int, error) {
@@ -147,7 +186,12 @@ func (d *Decoder) readHTn() (*hufftable, int, error) {
 		"HUFFVAL", huffvals,
 	)
 
-	return makeHufftable(tc, th, bits, huffvals), 17 + len(huffvals), nil
+	ht, err := makeHufftable(tc, th, bits, huffvals)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return ht, 17 + len(huffvals), nil
 }
 
 func (d *Decoder) readDHT() ([]*hufftable, error) {
@@ -174,8 +218,7 @@ func (d *Decoder) readDHT() ([]*hufftable, error) {
 			"Th", t.target,
 			"huffcode", t.huffcodes,
 			"maxcode", t.maxcode,
-			"mincode", t.mincode,
-			"valptr", t.valptr,
+			"valoff", t.valoff,
 		)
 		ret = append(ret, t)
 		rem -= l
@@ -187,37 +230,37 @@ func (d *Decoder) readDHT() ([]*hufftable, error) {
 func (d *Decoder) decodeHuffval(
 	ht *hufftable,
 ) (uint8, error) {
-	l := 1
-	code, err := d.nextBit()
-	if err != nil {
-		return 0, err
+	if ht == nil {
+		return 0, errors.New("huffman table is not defined")
 	}
 
-	for {
-		maxcd, ok := ht.maxcode[l]
-		if !ok {
-			return 0, errors.New("unexpected length in maxcode")
-		}
-		if int(code) <= maxcd {
-			break
-		}
+	if d.nacc < 16 {
+		d.fill()
+	}
 
-		nextbit, err := d.nextBit()
-		if err != nil {
-			return 0, err
+	// the bits after the end of the data are zeros in the accumulator
+	if e := ht.lookup[d.acc>>(64-lookupBits)]; e != 0 {
+		n := uint(e >> 8)
+		if n > d.nacc {
+			return 0, d.endOfData()
 		}
+		d.consume(n)
 
-		l++
-		code = (code << 1) + nextbit
+		return uint8(e), nil
 	}
 
-	mincd, ok := ht.mincode[l]
-	if !ok {
-		return 0, errors.New("unexpected length in mincode")
+	for l := uint(lookupBits + 1); l <= 16; l++ {
+		if l > d.nacc {
+			return 0, d.endOfData()
+		}
+
+		code := int32(d.acc >> (64 - l))
+		if code <= ht.maxcode[l-1] {
+			d.consume(l)
+
+			return ht.huffval[code+ht.valoff[l-1]], nil
+		}
 	}
-	j := ht.valptr[l]
-	j += int(code) - int(mincd)
-	val := ht.huffcodes[j].value
 
-	return val, nil
+	return 0, errors.New("invalid huffman code")
 }
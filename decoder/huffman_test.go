@@ -53,3 +53,106 @@ func TestDecodeHufftable(t *testing.T) {
 		}
 	}
 }
+
+// huffmanStream returns the entropy-coded data of the values by the table,
+// padded with 1-bits and stuffed.
+func huffmanStream(t *hufftable, values []uint8) []byte {
+	codes := make(map[uint8]*huffcode)
+	for _, c := range t.huffcodes {
+		codes[c.value] = c
+	}
+
+	var ret []byte
+	var acc uint32
+	var n int
+	put := func(v uint32, size int) {
+		acc = acc<<size | v
+		n += size
+		for n >= 8 {
+			b := byte(acc >> (n - 8))
+			ret = append(ret, b)
+			if b == 0xFF {
+				ret = append(ret, 0x00)
+			}
+			n -= 8
+		}
+	}
+	for _, v := range values {
+		c := codes[v]
+		put(uint32(c.code), c.size)
+	}
+	if n > 0 {
+		put(1<<(8-n)-1, 8-n)
+	}
+
+	return ret
+}
+
+func TestDecodeHuffval(t *testing.T) {
+	// the codes of the lengths from 2 to 16, longer than the lookup
+	var bits [16]uint8
+	var huffvals []*huffval
+	for i := 1; i < 16; i++ {
+		bits[i] = 1
+		huffvals = append(huffvals, &huffval{i: i, v: uint8(i * 10)})
+	}
+	bits[15] = 2
+	huffvals = append(huffvals, &huffval{i: 15, j: 1, v: 255})
+	ht, err := makeHufftable(0, 0, bits, huffvals)
+	if err != nil {
+		t.Fatalf("makeHufftable: %v", err)
+	}
+
+	var values []uint8
+	for i := 0; i < 100; i++ {
+		values = append(values, huffvals[(i*7)%len(huffvals)].v)
+	}
+
+	d := New(bytes.NewReader(huffmanStream(ht, values)))
+	for i, exp := range values {
+		v, err := d.decodeHuffval(ht)
+		if err != nil {
+			t.Fatalf("decodeHuffval[%d]: %v", i, err)
+		}
+
+		if v != exp {
+			t.Errorf("decodeHuffval[%d]=%d exp=%d", i, v, exp)
+		}
+	}
+
+	// the padding bits are not a code
+	if _, err := d.decodeHuffval(ht); err == nil {
+		t.Errorf("decodeHuffval of the padding")
+	}
+}
+
+func BenchmarkDecodeHuffval(b *testing.B) {
+	var bits [16]uint8
+	var huffvals []*huffval
+	for i, l := range []uint8{0, 2, 2, 3, 1, 1, 1, 1, 1, 1, 1, 1} {
+		bits[i] = l
+		for j := 0; j < int(l); j++ {
+			huffvals = append(huffvals, &huffval{i: i, j: j, v: uint8(len(huffvals))})
+		}
+	}
+	ht, err := makeHufftable(0, 0, bits, huffvals)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	values := make([]uint8, 4096)
+	for i := range values {
+		values[i] = uint8((i * i) % len(huffvals))
+	}
+	data := huffmanStream(ht, values)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := New(bytes.NewReader(data))
+		for range values {
+			if _, err := d.decodeHuffval(ht); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package decoder
+
+import (
+	"math"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// idctTable is the multipliers of the coefficients of an IDCT in natural order,
+// which are the quantization table scaled for the method as in libjpeg (jddctmgr.c).
+type idctTable struct {
+	ints   [64]int32
+	floats [64]float32
+}
+
+// newIDCTTable returns the multipliers of the quantization table qs in zigzag order
+// for the method and the precision p.
+func newIDCTTable(m DCTMethod, qs *[64]uint16, p uint8) *idctTable {
+	t := &idctTable{}
+	for i, k := range unzig {
+		q := int64(qs[k])
+		switch m {
+		case DCTIFast:
+			n := 14 - ifastScaleBits(p)
+			t.ints[i] = int32((q*int64(aanScaleFix[i]) + 1<<(n-1)) >> n)
+		case DCTFloat:
+			t.floats[i] = float32(float64(q) * aanScaleFactor[i/8] * aanScaleFactor[i%8] / 8)
+		default:
+			t.ints[i] = int32(q)
+		}
+	}
+	return t
+}
+
+// Constants of the integer IDCT of libjpeg (jidctint.c), scaled by 2^13.
+const (
+	idctConstBits = 13
+
+	fix0_298631336 = 2446
+	fix0_390180644 = 3196
+	fix0_541196100 = 4433
+	fix0_765366865 = 6270
+	fix0_899976223 = 7373
+	fix1_175875602 = 9633
+	fix1_501321110 = 12299
+	fix1_847759065 = 15137
+	fix1_961570560 = 16069
+	fix2_053119869 = 16819
+	fix2_562915447 = 20995
+	fix3_072711026 = 25172
+)
+
+func descale(x int32, n uint) int32 {
+	return (x + 1<<(n-1)) >> n
+}
+
+// pass1Bits returns the scale of the intermediate values of the integer IDCTs
+// (libjpeg PASS1_BITS), which is less for 12-bit samples to avoid overflow.
+func pass1Bits(p uint8) uint {
+	if p > 8 {
+		return 1
+	}
+	return 2
+}
+
+// levelShift returns the function which level shifts and clamps the outputs of the
+// IDCT for the precision p. The outputs of differential frames are not changed.
+func levelShift(p uint8, differential bool) func(v int32) int32 {
+	if differential {
+		return func(v int32) int32 {
+			return v
+		}
+	}
+
+	center, maxv := int32(1)<<(p-1), int32(1)<<p-1
+	return func(v int32) int32 {
+		return min(max(v+center, 0), maxv)
+	}
+}
+
+// idctIslow transforms the coefficients in natural order into the samples of the
+// precision p with the separable integer IDCT of libjpeg (jpeg_idct_islow).
+func idctIslow(in *block, t *idctTable, out *block, p uint8, differential bool) {
+	pass1 := pass1Bits(p)
+	shift := levelShift(p, differential)
+
+	var ws [64]int32
+	for i, v := range in {
+		ws[i] = v * t.ints[i]
+	}
+
+	// columns
+	for c := 0; c < 8; c++ {
+		if ws[8+c] == 0 && ws[16+c] == 0 && ws[24+c] == 0 && ws[32+c] == 0 &&
+			ws[40+c] == 0 && ws[48+c] == 0 && ws[56+c] == 0 {
+			ws[c] <<= pass1
+			for r := 8; r < 64; r += 8 {
+				ws[r+c] = ws[c]
+			}
+			continue
+		}
+
+		// even part
+		z2, z3 := ws[16+c], ws[48+c]
+		z1 := (z2 + z3) * fix0_541196100
+		tmp2 := z1 - z3*fix1_847759065
+		tmp3 := z1 + z2*fix0_765366865
+
+		z2, z3 = ws[c], ws[32+c]
+		tmp0 := (z2 + z3) << idctConstBits
+		tmp1 := (z2 - z3) << idctConstBits
+
+		tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+		tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+
+		// odd part
+		tmp0, tmp1, tmp2, tmp3 = oddPart(ws[56+c], ws[40+c], ws[24+c], ws[8+c])
+
+		n := idctConstBits - pass1
+		ws[c] = descale(tmp10+tmp3, n)
+		ws[56+c] = descale(tmp10-tmp3, n)
+		ws[8+c] = descale(tmp11+tmp2, n)
+		ws[48+c] = descale(tmp11-tmp2, n)
+		ws[16+c] = descale(tmp12+tmp1, n)
+		ws[40+c] = descale(tmp12-tmp1, n)
+		ws[24+c] = descale(tmp13+tmp0, n)
+		ws[32+c] = descale(tmp13-tmp0, n)
+	}
+
+	// rows
+	for r := 0; r < 64; r += 8 {
+		row := ws[r : r+8]
+
+		z2, z3 := row[2], row[6]
+		z1 := (z2 + z3) * fix0_541196100
+		tmp2 := z1 - z3*fix1_847759065
+		tmp3 := z1 + z2*fix0_765366865
+
+		tmp0 := (row[0] + row[4]) << idctConstBits
+		tmp1 := (row[0] - row[4]) << idctConstBits
+
+		tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+		tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+
+		tmp0, tmp1, tmp2, tmp3 = oddPart(row[7], row[5], row[3], row[1])
+
+		n := idctConstBits + pass1 + 3
+		out[r] = shift(descale(tmp10+tmp3, n))
+		out[r+7] = shift(descale(tmp10-tmp3, n))
+		out[r+1] = shift(descale(tmp11+tmp2, n))
+		out[r+6] = shift(descale(tmp11-tmp2, n))
+		out[r+2] = shift(descale(tmp12+tmp1, n))
+		out[r+5] = shift(descale(tmp12-tmp1, n))
+		out[r+3] = shift(descale(tmp13+tmp0, n))
+		out[r+4] = shift(descale(tmp13-tmp0, n))
+	}
+}
+
+// oddPart returns the odd part of the integer IDCT of the inputs 7, 5, 3 and 1.
+func oddPart(tmp0, tmp1, tmp2, tmp3 int32) (int32, int32, int32, int32) {
+	z1, z2 := tmp0+tmp3, tmp1+tmp2
+	z3, z4 := tmp0+tmp2, tmp1+tmp3
+	z5 := (z3 + z4) * fix1_175875602
+
+	tmp0 *= fix0_298631336
+	tmp1 *= fix2_053119869
+	tmp2 *= fix3_072711026
+	tmp3 *= fix1_501321110
+	z1 *= -fix0_899976223
+	z2 *= -fix2_562915447
+	z3 = z3*-fix1_961570560 + z5
+	z4 = z4*-fix0_390180644 + z5
+
+	return tmp0 + z1 + z3, tmp1 + z2 + z4, tmp2 + z2 + z3, tmp3 + z1 + z4
+}
+
+// aanScaleFactor is the scale factors of the AAN algorithm, aanscalefactor of libjpeg.
+var aanScaleFactor [8]float64
+
+// aanScaleFix is the products of the scale factors of the rows and columns scaled
+// by 2^14, aanscales of libjpeg.
+var aanScaleFix [64]int32
+
+func init() {
+	aanScaleFactor[0] = 1
+	for k := 1; k < 8; k++ {
+		aanScaleFactor[k] = math.Cos(float64(k)*math.Pi/16) * math.Sqrt2
+	}
+	for i := range aanScaleFix {
+		aanScaleFix[i] = int32(math.Round(aanScaleFactor[i/8] * aanScaleFactor[i%8] * (1 << 14)))
+	}
+}
+
+// Constants of the fast integer IDCT of libjpeg (jidctfst.c), scaled by 2^8.
+const (
+	ifastConstBits = 8
+
+	ifix1_082392200 = 277
+	ifix1_414213562 = 362
+	ifix1_847759065 = 473
+	ifix2_613125930 = 669
+)
+
+// ifastScaleBits returns the number of the fractional bits of the multipliers of
+// the fast integer IDCT (libjpeg IFAST_SCALE_BITS).
+func ifastScaleBits(p uint8) uint {
+	if p > 8 {
+		return 13
+	}
+	return 2
+}
+
+// ifastMul multiplies x by the constant c scaled by 2^8, truncating the product as libjpeg.
+func ifastMul(x, c int32) int32 {
+	return (x * c) >> ifastConstBits
+}
+
+// idctIFast transforms the coefficients in natural order into the samples of the
+// precision p with the AAN integer IDCT of libjpeg (jpeg_idct_ifast).
+func idctIFast(in *block, t *idctTable, out *block, p uint8, differential bool) {
+	pass1 := pass1Bits(p)
+	shift := levelShift(p, differential)
+
+	var ws [64]int32
+	if n := ifastScaleBits(p) - pass1; n > 0 {
+		for i, v := range in {
+			ws[i] = int32((int64(v)*int64(t.ints[i]) + 1<<(n-1)) >> n)
+		}
+	} else {
+		for i, v := range in {
+			ws[i] = v * t.ints[i]
+		}
+	}
+
+	// columns
+	for c := 0; c < 8; c++ {
+		if ws[8+c] == 0 && ws[16+c] == 0 && ws[24+c] == 0 && ws[32+c] == 0 &&
+			ws[40+c] == 0 && ws[48+c] == 0 && ws[56+c] == 0 {
+			for r := 8; r < 64; r += 8 {
+				ws[r+c] = ws[c]
+			}
+			continue
+		}
+		aanInt(&ws, c, 8)
+	}
+
+	// rows
+	for r := 0; r < 64; r += 8 {
+		aanInt(&ws, r, 1)
+		for c, v := range ws[r : r+8] {
+			out[r+c] = shift(v >> (pass1 + 3))
+		}
+	}
+}
+
+// aanInt transforms the 8 scaled inputs of v from i by the stride with the 1-D IDCT
+// of the AAN algorithm in integers.
+func aanInt(v *[64]int32, i, stride int) {
+	// even part
+	tmp0, tmp1, tmp2, tmp3 := v[i], v[i+2*stride], v[i+4*stride], v[i+6*stride]
+
+	tmp10, tmp11 := tmp0+tmp2, tmp0-tmp2
+	tmp13 := tmp1 + tmp3
+	tmp12 := ifastMul(tmp1-tmp3, ifix1_414213562) - tmp13
+
+	tmp0, tmp3 = tmp10+tmp13, tmp10-tmp13
+	tmp1, tmp2 = tmp11+tmp12, tmp11-tmp12
+
+	// odd part
+	tmp4, tmp5, tmp6, tmp7 := v[i+stride], v[i+3*stride], v[i+5*stride], v[i+7*stride]
+
+	z13, z10 := tmp6+tmp5, tmp6-tmp5
+	z11, z12 := tmp4+tmp7, tmp4-tmp7
+
+	tmp7 = z11 + z13
+	tmp11 = ifastMul(z11-z13, ifix1_414213562)
+
+	z5 := ifastMul(z10+z12, ifix1_847759065)
+	tmp10 = ifastMul(z12, ifix1_082392200) - z5
+	tmp12 = ifastMul(z10, -ifix2_613125930) + z5
+
+	tmp6 = tmp12 - tmp7
+	tmp5 = tmp11 - tmp6
+	tmp4 = tmp10 + tmp5
+
+	v[i], v[i+7*stride] = tmp0+tmp7, tmp0-tmp7
+	v[i+stride], v[i+6*stride] = tmp1+tmp6, tmp1-tmp6
+	v[i+2*stride], v[i+5*stride] = tmp2+tmp5, tmp2-tmp5
+	v[i+4*stride], v[i+3*stride] = tmp3+tmp4, tmp3-tmp4
+}
+
+// idctFloat transforms the coefficients in natural order into the samples of the
+// precision p with the AAN float IDCT of libjpeg (jpeg_idct_float).
+func idctFloat(in *block, t *idctTable, out *block, p uint8, differential bool) {
+	shift := levelShift(p, differential)
+
+	var ws [64]float32
+	for i, v := range in {
+		ws[i] = float32(float32(v) * t.floats[i])
+	}
+
+	// columns
+	for c := 0; c < 8; c++ {
+		aan(&ws, c, 8)
+	}
+
+	// rows
+	for r := 0; r < 64; r += 8 {
+		aan(&ws, r, 1)
+		for c, v := range ws[r : r+8] {
+			out[r+c] = shift(roundFloat(v))
+		}
+	}
+}
+
+// roundFloat rounds half up the value.
+func roundFloat(v float32) int32 {
+	v += 0.5
+	ret := int32(v)
+	if float32(ret) > v {
+		ret--
+	}
+	return ret
+}
+
+// aan transforms the 8 scaled inputs of v from i by the stride with the 1-D IDCT
+// of the AAN algorithm. The products are converted to float32 explicitly to be
+// rounded, since the compiler may fuse them with the additions on some
+// architectures, which changes the results.
+func aan(v *[64]float32, i, stride int) {
+	// even part
+	tmp0, tmp1, tmp2, tmp3 := v[i], v[i+2*stride], v[i+4*stride], v[i+6*stride]
+
+	tmp10, tmp11 := tmp0+tmp2, tmp0-tmp2
+	tmp13 := tmp1 + tmp3
+	tmp12 := float32((tmp1-tmp3)*1.414213562) - tmp13
+
+	tmp0, tmp3 = tmp10+tmp13, tmp10-tmp13
+	tmp1, tmp2 = tmp11+tmp12, tmp11-tmp12
+
+	// odd part
+	tmp4, tmp5, tmp6, tmp7 := v[i+stride], v[i+3*stride], v[i+5*stride], v[i+7*stride]
+
+	z13, z10 := tmp6+tmp5, tmp6-tmp5
+	z11, z12 := tmp4+tmp7, tmp4-tmp7
+
+	tmp7 = z11 + z13
+	tmp11 = float32((z11 - z13) * 1.414213562)
+
+	z5 := float32((z10 + z12) * 1.847759065)
+	tmp10 = z5 - float32(z12*1.082392200)
+	tmp12 = z5 - float32(z10*2.613125930)
+
+	tmp6 = tmp12 - tmp7
+	tmp5 = tmp11 - tmp6
+	tmp4 = tmp10 - tmp5
+
+	v[i], v[i+7*stride] = tmp0+tmp7, tmp0-tmp7
+	v[i+stride], v[i+6*stride] = tmp1+tmp6, tmp1-tmp6
+	v[i+2*stride], v[i+5*stride] = tmp2+tmp5, tmp2-tmp5
+	v[i+3*stride], v[i+4*stride] = tmp3+tmp4, tmp3-tmp4
+}
+
+// idctMatrix transforms the coefficients in natural order into the samples of the
+// precision p by the matrix multiplication of float64.
+func idctMatrix(in *block, t *idctTable, out *block, p uint8, differential bool) {
+	var data [64]float64
+	for i, v := range in {
+		data[i] = float64(v * t.ints[i])
+	}
+	r := idct_(mat.NewDense(8, 8, data[:]))
+
+	shift := levelShift(p, differential)
+	for i := range out {
+		out[i] = shift(int32(math.Round(r.At(i/8, i%8))))
+	}
+}
@@ -0,0 +1,188 @@
+package decoder
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yunomu/jpeg/jpeg"
+)
+
+// randomBlock returns the coefficients in natural order of the IDCT of random
+// samples of the precision p quantized by q.
+func randomBlock(r *rand.Rand, p uint8, q int) block {
+	s := make([][]int16, 8)
+	for y := range s {
+		s[y] = make([]int16, 8)
+		for x := range s[y] {
+			s[y][x] = int16(r.Intn(1<<p) - 1<<(p-1))
+		}
+	}
+
+	var ret block
+	for y, row := range dct(s) {
+		for x, v := range row {
+			ret[y*8+x] = int32(math.Round(v / float64(q)))
+		}
+	}
+	return ret
+}
+
+// flatTable returns the multipliers of the quantization table of all q.
+func flatTable(m DCTMethod, p uint8, q int) *idctTable {
+	var qs [64]uint16
+	for i := range qs {
+		qs[i] = uint16(q)
+	}
+	return newIDCTTable(m, &qs, p)
+}
+
+const testQ = 4
+
+func testIDCT(t *testing.T, m DCTMethod, maxErr int32) {
+	f, err := idctMethod(m)
+	if err != nil {
+		t.Fatalf("idctMethod: %v", err)
+	}
+	r := rand.New(rand.NewSource(1))
+
+	for _, p := range []uint8{8, 12} {
+		tab := flatTable(m, p, testQ)
+		for _, differential := range []bool{false, true} {
+			for n := 0; n < 1000; n++ {
+				in := randomBlock(r, p, testQ)
+
+				b := make([][]float64, 8)
+				for y := range b {
+					b[y] = make([]float64, 8)
+					for x := range b[y] {
+						b[y][x] = float64(in[y*8+x] * testQ)
+					}
+				}
+				exp := jpeg.Idct(b)
+
+				var out block
+				f(&in, tab, &out, p, differential)
+				shift := levelShift(p, differential)
+				for i, v := range out {
+					e := shift(int32(math.Round(exp[i/8][i%8])))
+					if d := v - e; d > maxErr || d < -maxErr {
+						t.Fatalf("%v p=%d differential=%v [%d]: exp=%d act=%d", m, p, differential, i, e, v)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestIDCT_islow(t *testing.T) {
+	testIDCT(t, DCTISlow, 1)
+}
+
+func TestIDCT_float(t *testing.T) {
+	testIDCT(t, DCTFloat, 1)
+}
+
+func TestIDCT_matrix(t *testing.T) {
+	testIDCT(t, DCTMatrix, 0)
+}
+
+func TestIDCT_clamp(t *testing.T) {
+	for _, m := range []DCTMethod{DCTISlow, DCTIFast, DCTFloat, DCTMatrix} {
+		f, _ := idctMethod(m)
+		tab := flatTable(m, 8, 1)
+
+		var out block
+		f(&block{2000}, tab, &out, 8, false)
+		if out[0] != 255 {
+			t.Errorf("%v: out=%v", m, out[0])
+		}
+		f(&block{-2000}, tab, &out, 8, false)
+		if out[0] != 0 {
+			t.Errorf("%v: out=%v", m, out[0])
+		}
+		f(&block{-80}, tab, &out, 8, true)
+		if out[0] != -10 {
+			t.Errorf("%v: out=%v", m, out[0])
+		}
+	}
+}
+
+func benchmarkIDCT(b *testing.B, m DCTMethod) {
+	f, _ := idctMethod(m)
+	tab := flatTable(m, 8, testQ)
+	in := randomBlock(rand.New(rand.NewSource(1)), 8, testQ)
+	var out block
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f(&in, tab, &out, 8, false)
+	}
+}
+
+func BenchmarkIDCT_islow(b *testing.B) {
+	benchmarkIDCT(b, DCTISlow)
+}
+
+func BenchmarkIDCT_ifast(b *testing.B) {
+	benchmarkIDCT(b, DCTIFast)
+}
+
+func BenchmarkIDCT_float(b *testing.B) {
+	benchmarkIDCT(b, DCTFloat)
+}
+
+// readRawPlanes reads the planes of the components written by libjpeg with
+// raw_data_out, prefixed with the header "R Nc w0 h0 w1 h1 ...".
+func readRawPlanes(t *testing.T, name string) [][]byte {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	i := bytes.IndexByte(data, '\n')
+	f := strings.Fields(string(data[:i]))
+	data = data[i+1:]
+
+	var ret [][]byte
+	for k := 2; k+1 < len(f); k += 2 {
+		w, _ := strconv.Atoi(f[k])
+		h, _ := strconv.Atoi(f[k+1])
+		ret = append(ret, data[:w*h])
+		data = data[w*h:]
+	}
+	return ret
+}
+
+func TestDecode_dctMethod(t *testing.T) {
+	data, err := os.ReadFile("testdata/progressive.jpg")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	// the samples of the components decoded by libjpeg with dct_method
+	for _, m := range []DCTMethod{DCTISlow, DCTIFast, DCTFloat} {
+		exp := readRawPlanes(t, "testdata/progressive_"+m.String()+".raw")
+
+		hdrs, err := NewWithOptions(bytes.NewReader(data), &Options{DCTMethod: m}).decodeFrames(0)
+		if err != nil {
+			t.Fatalf("%v: decodeFrames: %v", m, err)
+		}
+		for k, p := range hdrs[0].params {
+			w := int(p.x)
+			for i, v := range exp[k] {
+				if act := p.pix[i/w*p.stride+i%w]; act != uint16(v) {
+					t.Fatalf("%v: component %d mismatch at (%d, %d): exp=%d act=%d", m, k, i%w, i/w, v, act)
+				}
+			}
+		}
+	}
+
+	if _, err := NewWithOptions(bytes.NewReader(data), &Options{DCTMethod: 4}).Decode(); err != ErrInvalidDCTMethod {
+		t.Errorf("err=%v", err)
+	}
+}
@@ -1,48 +1,291 @@
 package decoder
 
 import (
+	"errors"
 	"image"
+	"image/color"
 )
 
-func makeImage_(h *frameHeader, cs map[uint8][]block) image.Image {
-	vx := padding(8*int(h.hMax), int(h.x))
-	vy := padding(8*int(h.vMax), int(h.y))
-	img := image.NewYCbCr(image.Rect(0, 0, vx, vy), image.YCbCrSubsampleRatio420)
-
-	for _, fp := range h.params {
-		blocks := cs[fp.c]
-		var x, y int
-		var cnt int
-		for {
-			for i := 0; i < int(fp.v); i++ {
-				for j := 0; j < int(fp.h); j++ {
-					for bi, v := range blocks[cnt] {
-						by := bi / 8
-						bx := bi % 8
-						idx := x + j*8 + bx + (y+i*8+by)*int(fp.x)
-						switch fp.c {
-						case 1:
-							img.Y[idx] = uint8(v)
-						case 2:
-							img.Cb[idx] = uint8(v)
-						case 3:
-							img.Cr[idx] = uint8(v)
-						}
-					}
-					cnt++
+var (
+	ErrUnsupportedComponents = errors.New("unsupported number of components")
+)
+
+// colorModel returns the color model of the image of the frame in the color
+// space cs, or ErrUnsupportedComponents if newImage can't make it.
+func colorModel(h *frameHeader, cs colorSpace) (color.Model, error) {
+	if h.p > 8 || h.isLossless() {
+		switch cs {
+		case colorSpaceGray:
+			return color.Gray16Model, nil
+		case colorSpaceYCbCr, colorSpaceRGB:
+			return color.RGBA64Model, nil
+		}
+		return nil, ErrUnsupportedComponents
+	}
+
+	switch cs {
+	case colorSpaceGray:
+		return color.GrayModel, nil
+	case colorSpaceYCbCr:
+		return color.YCbCrModel, nil
+	case colorSpaceRGB:
+		return color.RGBAModel, nil
+	case colorSpaceCMYK, colorSpaceYCCK:
+		return color.CMYKModel, nil
+	}
+	return nil, ErrUnsupportedComponents
+}
+
+// expand16 scales a sample of precision p to 16 bits by replicating its bits.
+func expand16(v uint16, p uint8) uint16 {
+	var ret uint32
+	for sh := 16 - int(p); sh > -int(p); sh -= int(p) {
+		if sh >= 0 {
+			ret |= uint32(v) << sh
+		} else {
+			ret |= uint32(v) >> -sh
+		}
+	}
+	return uint16(ret)
+}
+
+// at returns the sample of the component at (x, y) in image coordinates.
+func (p *frameComponentParam) at(h *frameHeader, x, y int) uint16 {
+	sx := x * int(p.h) / int(h.hMax)
+	sy := y * int(p.v) / int(h.vMax)
+	return p.pix[sy*p.stride+sx]
+}
+
+func clamp(v, maxv int64) int64 {
+	if v < 0 {
+		return 0
+	} else if v > maxv {
+		return maxv
+	}
+	return v
+}
+
+// ycbcrToRGB converts a YCbCr sample of precision p to RGB as in JFIF.
+func ycbcrToRGB(y, cb, cr uint16, p uint8) (uint16, uint16, uint16) {
+	c := int64(1) << (p - 1)
+	maxv := int64(1)<<p - 1
+
+	yy := int64(y) << 16
+	cb1 := int64(cb) - c
+	cr1 := int64(cr) - c
+
+	r := (yy + 91881*cr1 + 1<<15) >> 16
+	g := (yy - 22554*cb1 - 46802*cr1 + 1<<15) >> 16
+	b := (yy + 116130*cb1 + 1<<15) >> 16
+
+	return uint16(clamp(r, maxv)), uint16(clamp(g, maxv)), uint16(clamp(b, maxv))
+}
+
+// rowsFunc converts the samples of the image rows [y0, y1) of the frame into the image.
+type rowsFunc func(y0, y1 int)
+
+func newImage16(h *frameHeader, cs colorSpace) (image.Image, rowsFunc, error) {
+	rect := image.Rect(0, 0, int(h.x), int(h.y))
+
+	if cs == colorSpaceGray {
+		img := image.NewGray16(rect)
+		fp := h.params[0]
+		return img, func(y0, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := 0; x < int(h.x); x++ {
+					img.SetGray16(x, y, color.Gray16{Y: expand16(fp.at(h, x, y), h.p)})
+				}
+			}
+		}, nil
+	}
+
+	if cs != colorSpaceYCbCr && cs != colorSpaceRGB {
+		return nil, nil, ErrUnsupportedComponents
+	}
+
+	img := image.NewRGBA64(rect)
+	return img, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < int(h.x); x++ {
+				r, g, b := h.params[0].at(h, x, y), h.params[1].at(h, x, y), h.params[2].at(h, x, y)
+				if cs == colorSpaceYCbCr {
+					r, g, b = ycbcrToRGB(r, g, b, h.p)
+				}
+				img.SetRGBA64(x, y, color.RGBA64{
+					R: expand16(r, h.p),
+					G: expand16(g, h.p),
+					B: expand16(b, h.p),
+					A: 0xFFFF,
+				})
+			}
+		}
+	}, nil
+}
+
+// subsampleRatio returns the subsample ratio of image.YCbCr for the sampling factors
+// of the components. It returns false if image.YCbCr can't represent them.
+func subsampleRatio(h *frameHeader, y, cb, cr *frameComponentParam) (image.YCbCrSubsampleRatio, bool) {
+	if y.h != h.hMax || y.v != h.vMax || cb.h != cr.h || cb.v != cr.v || y.h%cb.h != 0 || y.v%cb.v != 0 {
+		return 0, false
+	}
+
+	switch [2]uint8{y.h / cb.h, y.v / cb.v} {
+	case [2]uint8{1, 1}:
+		return image.YCbCrSubsampleRatio444, true
+	case [2]uint8{2, 1}:
+		return image.YCbCrSubsampleRatio422, true
+	case [2]uint8{2, 2}:
+		return image.YCbCrSubsampleRatio420, true
+	case [2]uint8{1, 2}:
+		return image.YCbCrSubsampleRatio440, true
+	case [2]uint8{4, 1}:
+		return image.YCbCrSubsampleRatio411, true
+	case [2]uint8{4, 2}:
+		return image.YCbCrSubsampleRatio410, true
+	}
+
+	return 0, false
+}
+
+// copyPlane copies the samples of the component to the rows [y0, y1) of the plane
+// of w samples per row.
+func (p *frameComponentParam) copyPlane(dst []uint8, stride, w, y0, y1 int) {
+	for y := y0; y < y1; y++ {
+		src := p.pix[min(y, int(p.y)-1)*p.stride:]
+		for x := 0; x < w; x++ {
+			dst[y*stride+x] = uint8(src[min(x, int(p.x)-1)])
+		}
+	}
+}
+
+func newYCbCr(h *frameHeader) (image.Image, rowsFunc) {
+	y, cb, cr := h.params[0], h.params[1], h.params[2]
+
+	rect := image.Rect(0, 0, int(h.x), int(h.y))
+	ratio, ok := subsampleRatio(h, y, cb, cr)
+	if !ok {
+		// upsample unusual sampling factors to full resolution by replication
+		img := image.NewYCbCr(rect, image.YCbCrSubsampleRatio444)
+		return img, func(y0, y1 int) {
+			for yy := y0; yy < y1; yy++ {
+				for x := 0; x < int(h.x); x++ {
+					i := yy*img.YStride + x
+					img.Y[i] = uint8(y.at(h, x, yy))
+					img.Cb[i] = uint8(cb.at(h, x, yy))
+					img.Cr[i] = uint8(cr.at(h, x, yy))
 				}
 			}
+		}
+	}
 
-			x += int(fp.h) * 8
-			if x >= int(fp.x) {
-				x = 0
-				y += int(fp.v) * 8
-				if y >= int(fp.y) {
-					break
+	img := image.NewYCbCr(rect, ratio)
+	cw := img.CStride
+	ch := len(img.Cb) / cw
+	return img, func(y0, y1 int) {
+		// the rows of the chroma planes subsampled vertically
+		cy0, cy1 := y0*int(cb.v)/int(h.vMax), y1*int(cb.v)/int(h.vMax)
+		if y1 == int(h.y) {
+			cy1 = ch
+		}
+
+		y.copyPlane(img.Y, img.YStride, int(h.x), y0, y1)
+		cb.copyPlane(img.Cb, img.CStride, cw, cy0, cy1)
+		cr.copyPlane(img.Cr, img.CStride, cw, cy0, cy1)
+	}
+}
+
+func newGray(h *frameHeader) (image.Image, rowsFunc) {
+	img := image.NewGray(image.Rect(0, 0, int(h.x), int(h.y)))
+	return img, func(y0, y1 int) {
+		h.params[0].copyPlane(img.Pix, img.Stride, int(h.x), y0, y1)
+	}
+}
+
+func newRGBA(h *frameHeader) (image.Image, rowsFunc) {
+	img := image.NewRGBA(image.Rect(0, 0, int(h.x), int(h.y)))
+	r, g, b := h.params[0], h.params[1], h.params[2]
+	return img, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < int(h.x); x++ {
+				i := y*img.Stride + x*4
+				img.Pix[i] = uint8(r.at(h, x, y))
+				img.Pix[i+1] = uint8(g.at(h, x, y))
+				img.Pix[i+2] = uint8(b.at(h, x, y))
+				img.Pix[i+3] = 0xFF
+			}
+		}
+	}
+}
+
+// newCMYK makes the image of a CMYK or YCCK frame. The components of a file
+// with the Adobe segment are inverted as Photoshop writes them.
+func newCMYK(h *frameHeader, cs colorSpace, inverted bool) (image.Image, rowsFunc) {
+	img := image.NewCMYK(image.Rect(0, 0, int(h.x), int(h.y)))
+	c, m, y, k := h.params[0], h.params[1], h.params[2], h.params[3]
+	return img, func(y0, y1 int) {
+		for yy := y0; yy < y1; yy++ {
+			for x := 0; x < int(h.x); x++ {
+				s := [4]uint8{uint8(c.at(h, x, yy)), uint8(m.at(h, x, yy)), uint8(y.at(h, x, yy)), uint8(k.at(h, x, yy))}
+				if cs == colorSpaceYCCK {
+					// the inverted CMY is coded as RGB
+					r, g, b := ycbcrToRGB(uint16(s[0]), uint16(s[1]), uint16(s[2]), 8)
+					s[0], s[1], s[2] = 255-uint8(r), 255-uint8(g), 255-uint8(b)
+				}
+				if inverted {
+					for i := range s {
+						s[i] = 255 - s[i]
+					}
 				}
+
+				copy(img.Pix[yy*img.Stride+x*4:], s[:])
 			}
 		}
 	}
+}
+
+// newImage allocates the image of the frame in the color space cs, and returns it
+// with the conversion of its rows. inverted tells that CMYK components are inverted.
+func newImage(h *frameHeader, cs colorSpace, inverted bool) (image.Image, rowsFunc, error) {
+	if h.p > 8 || h.isLossless() {
+		return newImage16(h, cs)
+	}
+
+	var img image.Image
+	var rows rowsFunc
+	switch cs {
+	case colorSpaceGray:
+		img, rows = newGray(h)
+	case colorSpaceYCbCr:
+		img, rows = newYCbCr(h)
+	case colorSpaceRGB:
+		img, rows = newRGBA(h)
+	case colorSpaceCMYK, colorSpaceYCCK:
+		img, rows = newCMYK(h, cs, inverted)
+	default:
+		return nil, nil, ErrUnsupportedComponents
+	}
+
+	return img, rows, nil
+}
+
+// makeImage_ makes the image of the frame in the color space cs.
+// inverted tells that CMYK components are inverted.
+func makeImage_(h *frameHeader, cs colorSpace, inverted bool) (image.Image, error) {
+	img, rows, err := newImage(h, cs, inverted)
+	if err != nil {
+		return nil, err
+	}
+	rows(0, int(h.y))
+
+	return img, nil
+}
+
+// makeImage makes the image of the frame decoded by d. The image of a frame
+// decoded by the pipeline is already made.
+func (d *Decoder) makeImage(h *frameHeader) (image.Image, error) {
+	if h.img != nil {
+		return h.img, nil
+	}
 
-	return img.SubImage(image.Rect(0, 0, int(h.x), int(h.y)))
+	return makeImage_(h, d.colorSpace(h), d.adobe != nil)
 }
@@ -0,0 +1,161 @@
+package decoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+)
+
+func TestDecode_subsampleRatio(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		ratio image.YCbCrSubsampleRatio
+	}{
+		{"testdata/subsample_444.jpg", image.YCbCrSubsampleRatio444},
+		{"testdata/subsample_422.jpg", image.YCbCrSubsampleRatio422},
+		{"testdata/subsample_440.jpg", image.YCbCrSubsampleRatio440},
+		{"testdata/subsample_411.jpg", image.YCbCrSubsampleRatio411},
+		{"testdata/subsample_410.jpg", image.YCbCrSubsampleRatio410},
+		{"testdata/progressive.jpg", image.YCbCrSubsampleRatio420},
+	} {
+		data, err := os.ReadFile(c.name)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+
+		exp, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("jpeg.Decode(%s): %v", c.name, err)
+		}
+
+		act := decodeFile(t, c.name)
+		img, ok := act.(*image.YCbCr)
+		if !ok {
+			t.Fatalf("%s: unexpected image type: %T", c.name, act)
+		}
+
+		if img.SubsampleRatio != c.ratio {
+			t.Errorf("%s: ratio=%v exp=%v", c.name, img.SubsampleRatio, c.ratio)
+		}
+
+		compareImage(t, exp, act, 4)
+	}
+}
+
+func TestDecode_subsampleUnusual(t *testing.T) {
+	// Y 2x2, Cb 1x2, Cr 2x1, decoded by libjpeg without fancy upsampling
+	f, err := os.Open("testdata/subsample_odd.png")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	exp, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+
+	act := decodeFile(t, "testdata/subsample_odd.jpg")
+	if img, ok := act.(*image.YCbCr); !ok || img.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+		t.Fatalf("unexpected image: %T", act)
+	}
+
+	compareImage(t, exp, act, 4)
+}
+
+func TestDecode_gray(t *testing.T) {
+	// 2x2 sampling factors, which don't change the size of the non-interleaved scan
+	data, err := os.ReadFile("testdata/gray.jpg")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	exp, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("jpeg.Decode: %v", err)
+	}
+
+	sof := bytes.Index(data, []byte{0xFF, byte(Marker_SOF0)})
+	sos := bytes.Index(data, []byte{0xFF, byte(Marker_SOS)})
+	for _, id := range []byte{1, 0, 'Y', 200} {
+		data[sof+10] = id
+		data[sos+5] = id
+
+		act, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Decode(C=%d): %v", id, err)
+		}
+
+		if _, ok := act.(*image.Gray); !ok {
+			t.Fatalf("C=%d: unexpected image type: %T", id, act)
+		}
+
+		compareImage(t, exp, act, 1)
+
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DecodeConfig(C=%d): %v", id, err)
+		}
+
+		if cfg.ColorModel != color.GrayModel {
+			t.Errorf("C=%d: unexpected color model", id)
+		}
+	}
+}
+
+func TestDecode_cmyk(t *testing.T) {
+	for _, name := range []string{
+		"testdata/cmyk.jpg", // Adobe transform 0
+		"testdata/ycck.jpg", // Adobe transform 2, Y and K sampled 2x2
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+
+		exp, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("jpeg.Decode(%s): %v", name, err)
+		}
+
+		act, ok := decodeFile(t, name).(*image.CMYK)
+		if !ok {
+			t.Fatalf("%s: unexpected image type", name)
+		}
+
+		for i, e := range exp.(*image.CMYK).Pix {
+			if a := act.Pix[i]; diff(uint32(e), uint32(a)) > 4 {
+				t.Fatalf("%s: mismatch at %d: exp=%d act=%d", name, i, e, a)
+			}
+		}
+
+		cfg, err := DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("DecodeConfig(%s): %v", name, err)
+		}
+
+		if cfg.ColorModel != color.CMYKModel {
+			t.Errorf("%s: unexpected color model", name)
+		}
+	}
+}
+
+func TestDecode_cmykNotInverted(t *testing.T) {
+	// same as cmyk.jpg without the Adobe segment
+	inv := decodeFile(t, "testdata/cmyk.jpg").(*image.CMYK)
+
+	act, ok := decodeFile(t, "testdata/cmyk_plain.jpg").(*image.CMYK)
+	if !ok {
+		t.Fatalf("unexpected image type")
+	}
+
+	for i, v := range inv.Pix {
+		if a := act.Pix[i]; a != 255-v {
+			t.Fatalf("mismatch at %d: exp=%d act=%d", i, 255-v, a)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package decoder
+
+import (
+	"errors"
+
+	"github.com/yunomu/jpeg/lib/delta"
+)
+
+var (
+	ErrInvalidLosslessScan = errors.New("invalid lossless scan parameters")
+)
+
+func validateLosslessScan(frameHeader *frameHeader, h *scanHeader, differential bool) error {
+	// differential frames are coded without prediction
+	if differential != (h.ss == 0) {
+		return ErrInvalidLosslessScan
+	}
+
+	if h.ss > 7 || h.se != 0 || h.ah != 0 || h.al >= frameHeader.p {
+		return ErrInvalidLosslessScan
+	}
+
+	return nil
+}
+
+// startLosslessInterval records where each component starts in a restart interval
+// beginning at the n-th MCU, where the prediction is reset (H.1.2.1).
+func startLosslessInterval(frameHeader *frameHeader, params []*componentParam, n int) {
+	if len(params) == 1 {
+		param := params[0]
+		param.x0, param.y0 = n%int(param.x), n/int(param.x)
+		return
+	}
+
+	mx, my := n%frameHeader.mcuX, n/frameHeader.mcuX
+	for _, param := range params {
+		param.x0, param.y0 = mx*int(param.h), my*int(param.v)
+	}
+}
+
+// decodeDiff decodes a difference value of the lossless process (H.1.2.2).
+func (d *Decoder) decodeDiff(ht *hufftable) (int32, error) {
+	ssss, err := d.decodeHuffval(ht)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case ssss == 0:
+		return 0, nil
+	case ssss == 16:
+		return 32768, nil
+	case ssss > 16:
+		return 0, errors.New("invalid difference category")
+	}
+
+	v, err := d.receive(int(ssss))
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(extend(v, int(ssss))), nil
+}
+
+// predict returns the prediction of the sample at (x, y) by the predictor
+// selection value of the scan (Table H.1).
+func (p *componentParam) predict(h *scanHeader, prec uint8, x, y int) int32 {
+	if h.ss == 0 {
+		// no prediction
+		return 0
+	}
+
+	fp := p.fp
+	sample := func(x, y int) int32 {
+		return int32(fp.pix[y*fp.stride+x] >> h.al)
+	}
+
+	switch {
+	case x == p.x0 && y == p.y0:
+		return 1 << (prec - h.al - 1)
+	case y == p.y0:
+		return sample(x-1, y)
+	case x == 0:
+		return sample(x, y-1)
+	}
+
+	return delta.Predictor(h.ss).PredictFrom(sample(x-1, y), sample(x, y-1), sample(x-1, y-1))
+}
+
+func (d *Decoder) decodeSample(frameHeader *frameHeader, scanHeader *scanHeader, param *componentParam, x, y int) error {
+	var diff int32
+	var err error
+	if d.arithmetic {
+		diff, err = d.decodeArithDiff(param, x, y)
+	} else {
+		diff, err = d.decodeDiff(param.dcHT)
+	}
+	if err != nil {
+		return err
+	}
+
+	fp := param.fp
+	v := (param.predict(scanHeader, frameHeader.p, x, y) + diff) & 0xFFFF
+	fp.pix[y*fp.stride+x] = uint16(v << scanHeader.al)
+
+	return nil
+}
+
+func (d *Decoder) decodeLosslessMCU(frameHeader *frameHeader, scanHeader *scanHeader, params []*componentParam, n int) error {
+	if len(params) == 1 {
+		// non-interleave
+		param := params[0]
+		return d.decodeSample(frameHeader, scanHeader, param, n%int(param.x), n/int(param.x))
+	}
+
+	mx, my := n%frameHeader.mcuX, n/frameHeader.mcuX
+	for _, param := range params {
+		for i := 0; i < int(param.v); i++ {
+			for j := 0; j < int(param.h); j++ {
+				if err := d.decodeSample(frameHeader, scanHeader, param, mx*int(param.h)+j, my*int(param.v)+i); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package decoder
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"testing"
+)
+
+// losslessSample is the source sample the lossless test files were encoded from.
+func losslessSample(c, x, y int, p, pt uint8) uint16 {
+	v := x*37 + y*101 + c*1000 + (x*y)%97*13
+	if x == 5 && y == 0 {
+		v += 32768
+	}
+	return uint16((v & (1<<p - 1)) >> pt << pt)
+}
+
+func decodeFrameFile(t *testing.T, name string) *frameHeader {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	d := New(bytes.NewReader(data))
+	if err := d.readSOI(); err != nil {
+		t.Fatalf("readSOI: %v", err)
+	}
+
+	misc, err := d.decodeMisc()
+	if err != nil {
+		t.Fatalf("decodeMisc: %v", err)
+	}
+
+	h, _, err := d.decodeFrame(misc)
+	if err != nil {
+		t.Fatalf("decodeFrame: %v", err)
+	}
+
+	return h
+}
+
+func checkLosslessComponent(t *testing.T, h *frameHeader, ci int, pt uint8) {
+	t.Helper()
+
+	fp := h.params[ci]
+	for y := 0; y < int(fp.y); y++ {
+		for x := 0; x < int(fp.x); x++ {
+			exp := losslessSample(ci, x, y, h.p, pt)
+			if act := fp.pix[y*fp.stride+x]; act != exp {
+				t.Fatalf("component %d (%d, %d): exp=%d act=%d", ci, x, y, exp, act)
+			}
+		}
+	}
+}
+
+func TestDecode_losslessPredictors(t *testing.T) {
+	// 16-bit, one non-interleaved scan per predictor, restart every 5 lines
+	h := decodeFrameFile(t, "testdata/lossless_pred.jpg")
+
+	if h.nf != 7 {
+		t.Fatalf("Nf=%d", h.nf)
+	}
+
+	for i := 0; i < 7; i++ {
+		var pt uint8
+		if i == 6 {
+			pt = 3
+		}
+		checkLosslessComponent(t, h, i, pt)
+	}
+}
+
+func TestDecode_losslessInterleaved(t *testing.T) {
+	// 8-bit, 3 components with 2x2 sampled first component
+	h := decodeFrameFile(t, "testdata/lossless_ycc.jpg")
+
+	for i := 0; i < 3; i++ {
+		checkLosslessComponent(t, h, i, 0)
+	}
+}
+
+func TestDecode_losslessGray(t *testing.T) {
+	// 12-bit, predictor 7 with point transform 1
+	img := decodeFile(t, "testdata/lossless_gray.jpg")
+
+	gray, ok := img.(*image.Gray16)
+	if !ok {
+		t.Fatalf("unexpected image type: %T", img)
+	}
+
+	b := gray.Bounds()
+	if b != image.Rect(0, 0, 23, 17) {
+		t.Fatalf("bounds=%v", b)
+	}
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			exp := expand16(losslessSample(0, x, y, 12, 1), 12)
+			if act := gray.Gray16At(x, y).Y; act != exp {
+				t.Fatalf("(%d, %d): exp=%d act=%d", x, y, exp, act)
+			}
+		}
+	}
+}
@@ -74,3 +74,7 @@ func (m Marker) isFrameMarker() bool {
 	}
 	return false
 }
+
+func (m Marker) isAPP() bool {
+	return m&0xF0 == Marker_APP_n
+}
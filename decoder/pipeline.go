@@ -0,0 +1,88 @@
+package decoder
+
+import (
+	"sync"
+)
+
+// pipelined tells whether the scan is decoded by the pipeline. The scan has to
+// complete the samples of the frame row by row.
+func (d *Decoder) pipelined(frameHeader *frameHeader, scanHeader *scanHeader) bool {
+	return !d.progressive && !d.differential && !d.hierarchical && !frameHeader.isLossless() &&
+		len(scanHeader.params) == len(frameHeader.params)
+}
+
+// decodePipeline runs decode, which entropy decodes the scan, and hands the MCU
+// rows to the goroutines of PipelineWorkers. They dequantize and transform the
+// blocks of the rows, and convert the samples into the image of the frame.
+// The scan is decoded without the pipeline if the image is not supported.
+func (d *Decoder) decodePipeline(frameHeader *frameHeader, params []*componentParam, decode func() error) error {
+	img, rows, err := newImage(frameHeader, d.colorSpace(frameHeader), d.adobe != nil)
+	if err != nil {
+		return decode()
+	}
+
+	idcts := make([]idctFunc, len(params))
+	tables := make([]*idctTable, len(params))
+	for i, param := range params {
+		idcts[i], tables[i], err = param.fp.idct(frameHeader.p, d.opts.DCTMethod)
+		if err != nil {
+			return err
+		}
+		param.fp.allocPix()
+	}
+
+	// an MCU row has v block rows of each component, or a block row of the
+	// single component of a non-interleaved scan
+	rowMCUs, rowHeight, nrows := frameHeader.mcuX, 8*int(frameHeader.vMax), frameHeader.mcuY
+	blockRows := func(param *componentParam) int { return int(param.v) }
+	if len(params) == 1 {
+		rowMCUs = padding(8, int(params[0].x)) / 8
+		rowHeight = 8
+		nrows = padding(8, int(params[0].y)) / 8
+		blockRows = func(*componentParam) int { return 1 }
+	}
+
+	ch := make(chan int, d.opts.PipelineWorkers)
+	var wg sync.WaitGroup
+	for w := 0; w < d.opts.PipelineWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for my := range ch {
+				for i, param := range params {
+					n := blockRows(param)
+					param.fp.reconstructRows(idcts[i], tables[i], frameHeader.p, false, my*n, min(my*n+n, param.fp.bh))
+				}
+
+				y0 := my * rowHeight
+				if y0 < int(frameHeader.y) {
+					rows(y0, min(y0+rowHeight, int(frameHeader.y)))
+				}
+			}
+		}()
+	}
+
+	var next int
+	d.mcuDone = func(n int) {
+		if (n+1)%rowMCUs == 0 {
+			ch <- n / rowMCUs
+			next = n/rowMCUs + 1
+		}
+	}
+	err = decode()
+	d.mcuDone = nil
+	if err == nil {
+		for ; next < nrows; next++ {
+			ch <- next
+		}
+	}
+	close(ch)
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	frameHeader.img = img
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package decoder
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecode_pipelineWorkers(t *testing.T) {
+	names, err := filepath.Glob("testdata/*.jpg")
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+
+	for _, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+
+		exp, expErr := Decode(bytes.NewReader(data))
+
+		for _, n := range []int{2, 3, 8} {
+			act, err := NewWithOptions(bytes.NewReader(data), &Options{PipelineWorkers: n}).Decode()
+			if err != expErr {
+				t.Fatalf("%s: workers=%d: err=%v exp=%v", name, n, err, expErr)
+			}
+
+			if !reflect.DeepEqual(exp, act) {
+				t.Errorf("%s: workers=%d: image mismatch", name, n)
+			}
+		}
+	}
+}
+
+func TestDecode_pipelineTruncated(t *testing.T) {
+	data, err := os.ReadFile("testdata/subsample_422.jpg")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	if _, err := NewWithOptions(bytes.NewReader(data[:len(data)/2]), &Options{PipelineWorkers: 4}).Decode(); err == nil {
+		t.Errorf("decoded truncated data")
+	}
+}
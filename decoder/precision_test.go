@@ -0,0 +1,117 @@
+package decoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// to12bit rewrites an 8-bit baseline stream as a 12-bit extended sequential
+// stream with the same entropy-coded data. Quantization tables are scaled
+// by 16, so the decoded samples are those of the original stream times 16.
+func to12bit(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	out := []byte{0xFF, 0xD8}
+	i := 2
+	for {
+		if data[i] != 0xFF {
+			t.Fatalf("marker not found at %d", i)
+		}
+		m := data[i+1]
+		l := int(data[i+2])<<8 | int(data[i+3])
+		seg := data[i+4 : i+2+l]
+
+		switch m {
+		case byte(Marker_DQT):
+			var body []byte
+			for j := 0; j < len(seg); j += 65 {
+				body = append(body, 0x10|seg[j]&0x0F)
+				for _, q := range seg[j+1 : j+65] {
+					v := uint16(q) * 16
+					body = append(body, byte(v>>8), byte(v))
+				}
+			}
+			out = append(out, 0xFF, m, byte((len(body)+2)>>8), byte(len(body)+2))
+			out = append(out, body...)
+		case byte(Marker_SOF0):
+			out = append(out, 0xFF, byte(Marker_SOF1), data[i+2], data[i+3], 12)
+			out = append(out, seg[1:]...)
+		case byte(Marker_SOS):
+			return append(out, data[i:]...)
+		default:
+			out = append(out, data[i:i+2+l]...)
+		}
+
+		i += 2 + l
+	}
+}
+
+func TestDecode_12bit(t *testing.T) {
+	data := encodeTestImage(t, testImage(67, 45))
+
+	exp, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("jpeg.Decode: %v", err)
+	}
+
+	act, err := Decode(bytes.NewReader(to12bit(t, data)))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if _, ok := act.(*image.RGBA64); !ok {
+		t.Fatalf("unexpected image type: %T", act)
+	}
+
+	compareImage(t, exp, act, 3)
+}
+
+func TestDecode_12bitGray(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 67, 45))
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 67; x++ {
+			src.SetGray(x, y, color.Gray{Y: uint8(x*3 + y)})
+		}
+	}
+	data := encodeTestImage(t, src)
+
+	exp, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("jpeg.Decode: %v", err)
+	}
+
+	act, err := Decode(bytes.NewReader(to12bit(t, data)))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	gray, ok := act.(*image.Gray16)
+	if !ok {
+		t.Fatalf("unexpected image type: %T", act)
+	}
+
+	compareImage(t, exp, gray, 2)
+}
+
+func TestExpand16(t *testing.T) {
+	for _, c := range []struct {
+		v   uint16
+		p   uint8
+		exp uint16
+	}{
+		{0, 12, 0},
+		{0xFFF, 12, 0xFFFF},
+		{0x800, 12, 0x8008},
+		{0xFF, 8, 0xFFFF},
+		{0x3, 2, 0xFFFF},
+		{0x2, 2, 0xAAAA},
+		{0x1234, 16, 0x1234},
+	} {
+		if act := expand16(c.v, c.p); act != c.exp {
+			t.Errorf("expand16(%#x, %d)=%#x exp=%#x", c.v, c.p, act, c.exp)
+		}
+	}
+}
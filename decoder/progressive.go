@@ -0,0 +1,207 @@
+package decoder
+
+import (
+	"errors"
+)
+
+var (
+	ErrInvalidProgressiveScan = errors.New("invalid progressive scan parameters")
+)
+
+func validateProgressiveScan(h *scanHeader) error {
+	if h.se > 63 || h.ss > h.se || h.al > 13 {
+		return ErrInvalidProgressiveScan
+	}
+
+	if h.ss == 0 {
+		if h.se != 0 {
+			return ErrInvalidProgressiveScan
+		}
+	} else if h.n != 1 {
+		// AC scans must be non-interleaved
+		return ErrInvalidProgressiveScan
+	}
+
+	if h.ah != 0 && h.ah-1 != h.al {
+		return ErrInvalidProgressiveScan
+	}
+
+	return nil
+}
+
+// decodeDCFirst decodes the first scan of DC coefficients (G.1.2.1).
+func (d *Decoder) decodeDCFirst(param *componentParam, al uint8, zz *block) error {
+	dc, err := d.decodeDC(param.dcHT)
+	if err != nil {
+		return err
+	}
+	zz[0] = d.dcValue(param.cs, dc) << al
+
+	return nil
+}
+
+// decodeDCRefine decodes a successive approximation refinement bit of the DC coefficient.
+func (d *Decoder) decodeDCRefine(al uint8, zz *block) error {
+	bit, err := d.nextBit()
+	if err != nil {
+		return err
+	}
+
+	if bit != 0 {
+		zz[0] |= 1 << al
+	}
+
+	return nil
+}
+
+func (d *Decoder) receiveEOBRUN(r int) error {
+	d.eobrun = 1 << r
+	if r == 0 {
+		return nil
+	}
+
+	v, err := d.receive(r)
+	if err != nil {
+		return err
+	}
+	d.eobrun += int(v)
+
+	return nil
+}
+
+// decodeACFirst decodes the first scan of a spectral band of AC coefficients (G.1.2.2).
+func (d *Decoder) decodeACFirst(param *componentParam, h *scanHeader, zz *block) error {
+	if d.eobrun > 0 {
+		d.eobrun--
+		return nil
+	}
+
+	for k := int(h.ss); k <= int(h.se); {
+		rs, err := d.decodeHuffval(param.acHT)
+		if err != nil {
+			return err
+		}
+
+		ssss := int(rs % 16)
+		r := int(rs >> 4)
+
+		if ssss == 0 {
+			if r == 15 {
+				k += 16
+				continue
+			}
+
+			if err := d.receiveEOBRUN(r); err != nil {
+				return err
+			}
+			d.eobrun--
+			break
+		}
+
+		k += r
+		if k > int(h.se) {
+			return errors.New("too many coefficients")
+		}
+
+		v, err := d.decodeZZ(ssss)
+		if err != nil {
+			return err
+		}
+		zz[k] = v << h.al
+		k++
+	}
+
+	return nil
+}
+
+// refineNonZero reads a correction bit for a coefficient that is already nonzero.
+func (d *Decoder) refineNonZero(v *int32, p1 int32) error {
+	bit, err := d.nextBit()
+	if err != nil {
+		return err
+	}
+
+	if bit == 0 || *v&p1 != 0 {
+		return nil
+	}
+
+	if *v >= 0 {
+		*v += p1
+	} else {
+		*v -= p1
+	}
+
+	return nil
+}
+
+// decodeACRefine decodes a successive approximation refinement scan of AC coefficients (G.1.2.3).
+func (d *Decoder) decodeACRefine(param *componentParam, h *scanHeader, zz *block) error {
+	p1 := int32(1) << h.al
+	k := int(h.ss)
+	se := int(h.se)
+
+	if d.eobrun == 0 {
+		for ; k <= se; k++ {
+			rs, err := d.decodeHuffval(param.acHT)
+			if err != nil {
+				return err
+			}
+
+			ssss := int(rs % 16)
+			r := int(rs >> 4)
+
+			var v int32
+			if ssss != 0 {
+				bit, err := d.nextBit()
+				if err != nil {
+					return err
+				}
+
+				if bit != 0 {
+					v = p1
+				} else {
+					v = -p1
+				}
+			} else if r != 15 {
+				if err := d.receiveEOBRUN(r); err != nil {
+					return err
+				}
+				break
+			}
+
+			// skip r zero coefficients, refining nonzero ones on the way
+			for ; k <= se; k++ {
+				if zz[k] != 0 {
+					if err := d.refineNonZero(&zz[k], p1); err != nil {
+						return err
+					}
+				} else {
+					if r == 0 {
+						break
+					}
+					r--
+				}
+			}
+
+			if v != 0 {
+				if k > se {
+					return errors.New("too many coefficients")
+				}
+				zz[k] = v
+			}
+		}
+	}
+
+	if d.eobrun > 0 {
+		for ; k <= se; k++ {
+			if zz[k] != 0 {
+				if err := d.refineNonZero(&zz[k], p1); err != nil {
+					return err
+				}
+			}
+		}
+		d.eobrun--
+	}
+
+	return nil
+}
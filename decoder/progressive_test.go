@@ -0,0 +1,98 @@
+package decoder
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+func decodeFile(t *testing.T, name string) image.Image {
+	t.Helper()
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode(%s): %v", name, err)
+	}
+
+	return img
+}
+
+func TestDecode_progressive(t *testing.T) {
+	data, err := os.ReadFile("testdata/progressive.jpg")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	exp, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("jpeg.Decode: %v", err)
+	}
+
+	act := decodeFile(t, "testdata/progressive.jpg")
+
+	compareImage(t, exp, act, 4)
+}
+
+func TestDecode_progressiveRestart(t *testing.T) {
+	exp := decodeFile(t, "testdata/progressive.jpg")
+	act := decodeFile(t, "testdata/progressive_rst.jpg")
+
+	compareImage(t, exp, act, 0)
+}
+
+func TestValidateProgressiveScan(t *testing.T) {
+	for _, c := range []struct {
+		h  scanHeader
+		ok bool
+	}{
+		{scanHeader{n: 3, ss: 0, se: 0, ah: 0, al: 1}, true},
+		{scanHeader{n: 3, ss: 0, se: 0, ah: 1, al: 0}, true},
+		{scanHeader{n: 1, ss: 1, se: 5, ah: 0, al: 2}, true},
+		{scanHeader{n: 1, ss: 6, se: 63, ah: 2, al: 1}, true},
+		{scanHeader{n: 3, ss: 0, se: 5, ah: 0, al: 0}, false},
+		{scanHeader{n: 2, ss: 1, se: 5, ah: 0, al: 0}, false},
+		{scanHeader{n: 1, ss: 6, se: 5, ah: 0, al: 0}, false},
+		{scanHeader{n: 1, ss: 1, se: 64, ah: 0, al: 0}, false},
+		{scanHeader{n: 1, ss: 1, se: 63, ah: 3, al: 1}, false},
+	} {
+		err := validateProgressiveScan(&c.h)
+		if (err == nil) != c.ok {
+			t.Errorf("validateProgressiveScan(%v)=%v", &c.h, err)
+		}
+	}
+}
+
+func TestDecodeAC_tooManyCoefficients(t *testing.T) {
+	// a single 1-bit code of the run of 5 zeros followed by a coefficient,
+	// which ends after Se of the band
+	var bits [16]uint8
+	bits[0] = 1
+	ht, err := makeHufftable(1, 0, bits, []*huffval{{v: 0x51}})
+	if err != nil {
+		t.Fatalf("makeHufftable: %v", err)
+	}
+	param := &componentParam{acHT: ht}
+	h := &scanHeader{n: 1, ss: 1, se: 5}
+
+	var zz block
+	d := New(bytes.NewReader([]byte{0x00, 0x00}))
+	if err := d.decodeACFirst(param, h, &zz); err == nil {
+		t.Errorf("decodeACFirst: no error")
+	}
+
+	h.ah, h.al = 1, 0
+	d = New(bytes.NewReader([]byte{0x00, 0x00}))
+	if err := d.decodeACRefine(param, h, &zz); err == nil {
+		t.Errorf("decodeACRefine: no error")
+	}
+	if zz != (block{}) {
+		t.Errorf("coefficients after Se are written: %v", zz)
+	}
+}
@@ -18,19 +18,20 @@ func (t *quantizationTable) String() string {
 	return fmt.Sprintf("(Pq=%d Tq=%d Q=%v)", t.precision, t.target, t.qs)
 }
 
-func (t *quantizationTable) Unquantize(zz block) block {
-	var ret block
-	for i, q := range t.qs {
-		ret[i] = zz[i] * int16(q)
+func replaceQuantizationTable(tables []*quantizationTable, t *quantizationTable) []*quantizationTable {
+	ret := []*quantizationTable{t}
+	for _, t1 := range tables {
+		if t1.target != t.target {
+			ret = append(ret, t1)
+		}
 	}
-
 	return ret
 }
 
 func (d *Decoder) readQT() (*quantizationTable, int, error) {
 	t, err := d.readUint8()
 	if err != nil {
-		return nil, 0, nil
+		return nil, 0, err
 	}
 	pq := t >> 4
 	tq := 0x0F & t
@@ -52,7 +53,7 @@ func (d *Decoder) readQT() (*quantizationTable, int, error) {
 			size += 2
 		}
 		if err != nil {
-			return nil, 0, nil
+			return nil, 0, err
 		}
 
 		qs[i] = v
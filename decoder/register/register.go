@@ -0,0 +1,21 @@
+// Package register registers the decoder with the standard image package.
+//
+// Blank-import it to route image.Decode and image.DecodeConfig to
+// decoder.Decoder for streams that start with SOI:
+//
+//	import _ "github.com/yunomu/jpeg/decoder/register"
+//
+// image.Decode uses the first registered format whose magic matches, so
+// this package must be initialized before image/jpeg when both are
+// linked into the same program.
+package register
+
+import (
+	"image"
+
+	"github.com/yunomu/jpeg/decoder"
+)
+
+func init() {
+	image.RegisterFormat("jpeg", "\xff\xd8", decoder.Decode, decoder.DecodeConfig)
+}
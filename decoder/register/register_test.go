@@ -0,0 +1,34 @@
+package register
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+func TestDecodeConfig(t *testing.T) {
+	data := []byte{
+		0xFF, 0xD8, // SOI
+		0xFF, 0xC0, // SOF0
+		0x00, 0x11, // Lf
+		0x08,       // P
+		0x00, 0x10, // Y
+		0x00, 0x20, // X
+		0x03,             // Nf
+		0x01, 0x22, 0x00, // C1
+		0x02, 0x11, 0x01, // C2
+		0x03, 0x11, 0x01, // C3
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("image.DecodeConfig: %v", err)
+	}
+
+	if format != "jpeg" {
+		t.Errorf("format=%v", format)
+	}
+	if cfg.Width != 32 || cfg.Height != 16 {
+		t.Errorf("size mismatch: exp=(32, 16) act=(%d, %d)", cfg.Width, cfg.Height)
+	}
+}
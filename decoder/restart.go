@@ -0,0 +1,100 @@
+package decoder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sync"
+)
+
+// readRestartIntervals reads the entropy-coded data of the n restart intervals
+// of the scan. Each interval keeps the marker which ends it, and the marker after
+// the scan is left unread.
+func (d *Decoder) readRestartIntervals(n int) ([][]byte, error) {
+	var ret [][]byte
+	var buf []byte
+	rst := 0
+	for {
+		b, m, err := d.readByteMarker()
+		if err != nil {
+			return nil, err
+		}
+
+		if m == 0 {
+			buf = append(buf, b)
+			if b == Marker_Prefix {
+				buf = append(buf, Marker_FF)
+			}
+			continue
+		}
+
+		buf = append(buf, Marker_Prefix, byte(m))
+		ret = append(ret, buf)
+		buf = nil
+		if m.RST() == -1 || len(ret) == n {
+			d.unread()
+			break
+		}
+
+		if m.RST() != rst {
+			return nil, errors.New("Invalid reset marker")
+		}
+		rst = (rst + 1) % 8
+	}
+
+	if len(ret) != n {
+		return nil, ErrUnexpectedMarker
+	}
+
+	return ret, nil
+}
+
+// decodeRestartIntervals decodes the restart intervals of the scan on the
+// goroutines of RestartWorkers. Each interval is decoded by its own Decoder from
+// the data read ahead, and writes the blocks of its MCUs.
+func (d *Decoder) decodeRestartIntervals(
+	frameHeader *frameHeader,
+	scanHeader *scanHeader,
+	params []*componentParam,
+	nmcu, ri int,
+) error {
+	data, err := d.readRestartIntervals((nmcu + ri - 1) / ri)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]error, len(data))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(d.opts.RestartWorkers, len(data)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sub := &Decoder{
+					r:            bufio.NewReader(bytes.NewReader(data[i])),
+					progressive:  d.progressive,
+					arithmetic:   d.arithmetic,
+					differential: d.differential,
+					opts:         d.opts,
+				}
+				begin := i * ri
+				errs[i] = sub.decodeRestartInterval(frameHeader, scanHeader, params, begin, min(ri, nmcu-begin))
+			}
+		}()
+	}
+
+	for i := range data {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
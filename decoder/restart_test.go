@@ -0,0 +1,86 @@
+package decoder
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestDecode_restartWorkers(t *testing.T) {
+	for _, name := range []string{
+		"restart.jpg",
+		"progressive_rst.jpg",
+		"arith.jpg",
+		"lossless_pred.jpg",
+		"hierarchical_lossless.jpg",
+	} {
+		data, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+
+		exp, err := New(bytes.NewReader(data)).decodeFrames(0)
+		if err != nil {
+			t.Fatalf("%s: decodeFrames: %v", name, err)
+		}
+
+		for _, n := range []int{2, 4, 100} {
+			act, err := NewWithOptions(bytes.NewReader(data), &Options{RestartWorkers: n}).decodeFrames(0)
+			if err != nil {
+				t.Fatalf("%s: workers=%d: decodeFrames: %v", name, n, err)
+			}
+
+			for i, h := range exp {
+				for k, p := range h.params {
+					if !reflect.DeepEqual(p.pix, act[i].params[k].pix) {
+						t.Errorf("%s: workers=%d: frame %d component %d mismatch", name, n, i, k)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDecode_restartWorkersInvalid(t *testing.T) {
+	data, err := os.ReadFile("testdata/restart.jpg")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	// swap RST0 and RST1
+	i := bytes.Index(data, []byte{0xFF, 0xD0})
+	j := bytes.Index(data, []byte{0xFF, 0xD1})
+	if i < 0 || j < 0 {
+		t.Fatalf("RST markers not found")
+	}
+	data = bytes.Clone(data)
+	data[i+1], data[j+1] = 0xD1, 0xD0
+
+	if _, err := NewWithOptions(bytes.NewReader(data), &Options{RestartWorkers: 4}).Decode(); err == nil {
+		t.Errorf("decoded invalid restart markers")
+	}
+
+	// truncated in the scan
+	if _, err := NewWithOptions(bytes.NewReader(data[:j]), &Options{RestartWorkers: 4}).Decode(); err == nil {
+		t.Errorf("decoded truncated data")
+	}
+}
+
+func BenchmarkDecode_restart(b *testing.B) {
+	data, err := os.ReadFile("testdata/restart.jpg")
+	if err != nil {
+		b.Fatalf("ReadFile: %v", err)
+	}
+
+	for _, n := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := NewWithOptions(bytes.NewReader(data), &Options{RestartWorkers: n}).Decode(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -4,30 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"math"
 
-	"gonum.org/v1/gonum/mat"
+	"github.com/yunomu/jpeg/jpeg"
 )
 
-var unzig []int = []int{
-	0, 1, 5, 6, 14, 15, 27, 28,
-	2, 4, 7, 13, 16, 26, 29, 42,
-	3, 8, 12, 17, 25, 30, 41, 43,
-	9, 11, 18, 24, 31, 40, 44, 53,
-	10, 19, 23, 32, 39, 45, 52, 54,
-	20, 22, 33, 38, 46, 51, 55, 60,
-	21, 34, 37, 47, 50, 56, 59, 61,
-	35, 36, 48, 49, 57, 58, 62, 63,
-}
-
-func zzToMatrix(zz block) *mat.Dense {
-	var data [blockSize]float64
-	for i, n := range unzig {
-		data[i] = float64(zz[n])
-	}
-
-	return mat.NewDense(8, 8, data[:])
-}
+var unzig = jpeg.Unzig
 
 type scanComponentParam struct {
 	cs     uint8
@@ -59,6 +40,14 @@ type componentParam struct {
 	qt    *quantizationTable // quantization table
 	dcHT  *hufftable         // huffman code tables for DC
 	acHT  *hufftable         // huffman code tables for AC
+
+	td, ta         uint8              // entropy coding table destinations
+	dcCond, acCond *conditioningTable // arithmetic conditioning tables
+
+	fp *frameComponentParam
+
+	x0, y0 int     // first sample of the restart interval in lossless scans
+	diffs  []int32 // differences of the arithmetic-coded lossless scan
 }
 
 func (p *componentParam) String() string {
@@ -104,6 +93,7 @@ func getComponentParams(
 	frameHeader *frameHeader,
 	quantizationTables []*quantizationTable,
 	hufftables []*hufftable,
+	conditioningTables []*conditioningTable,
 	scanHeader *scanHeader,
 ) ([]*componentParam, int, error) {
 	var ret []*componentParam
@@ -123,19 +113,27 @@ func getComponentParams(
 			qt:    findQuantizationTable(quantizationTables, fp.tq),
 			dcHT:  findHufftable(hufftables, 0, sp.td),
 			acHT:  findHufftable(hufftables, 1, sp.ta),
+
+			td:     sp.td,
+			ta:     sp.ta,
+			dcCond: findConditioningTable(conditioningTables, 0, sp.td),
+			acCond: findConditioningTable(conditioningTables, 1, sp.ta),
+
+			fp: fp,
 		})
 	}
 
+	unit := frameHeader.unitSize()
 	if len(ret) == 1 {
 		// non-interleave
 		ret[0].nunit = 1
-		return ret, padding(8, int(ret[0].x)) * padding(8, int(ret[0].y)) / (8 * 8), nil
+		return ret, padding(unit, int(ret[0].x)) * padding(unit, int(ret[0].y)) / (unit * unit), nil
 	}
 
 	var nmcu int
 	for _, p := range ret {
-		tx := 8 * int(p.h)
-		ty := 8 * int(p.v)
+		tx := unit * int(p.h)
+		ty := unit * int(p.v)
 		n := padding(tx, int(p.x)) * padding(ty, int(p.y)) / (tx * ty)
 		if nmcu == 0 {
 			nmcu = n
@@ -207,7 +205,7 @@ func (d *Decoder) decodeScanHeader() (*scanHeader, error) {
 	}, nil
 }
 
-func extend(v_ uint8, t int) int16 {
+func extend(v_ uint16, t int) int16 {
 	if t == 0 {
 		return 0
 	}
@@ -221,7 +219,7 @@ func extend(v_ uint8, t int) int16 {
 	return v
 }
 
-func (d *Decoder) decodeDC(ht *hufftable) (int16, error) {
+func (d *Decoder) decodeDC(ht *hufftable) (int32, error) {
 	l_, err := d.decodeHuffval(ht)
 	if err != nil {
 		return 0, err
@@ -236,7 +234,7 @@ func (d *Decoder) decodeDC(ht *hufftable) (int16, error) {
 		return 0, err
 	}
 
-	return extend(v, l), nil
+	return int32(extend(v, l)), nil
 }
 
 type ob uint8
@@ -245,13 +243,13 @@ func (b ob) String() string {
 	return fmt.Sprintf("%08b", b)
 }
 
-func (d *Decoder) decodeZZ(ssss int) (int16, error) {
+func (d *Decoder) decodeZZ(ssss int) (int32, error) {
 	v, err := d.receive(ssss)
 	if err != nil {
 		return 0, err
 	}
 
-	return extend(v, ssss), nil
+	return int32(extend(v, ssss)), nil
 }
 
 func (d *Decoder) decodeACs(ht *hufftable) (block, error) {
@@ -271,12 +269,18 @@ func (d *Decoder) decodeACs(ht *hufftable) (block, error) {
 		if ssss == 0 {
 			if r == 15 {
 				k += 16
+				if k > 63 {
+					return block{}, errors.New("too many coefficients")
+				}
 				continue
 			}
 			break
 		}
 
 		k += r
+		if k > 63 {
+			return block{}, errors.New("too many coefficients")
+		}
 
 		v, err := d.decodeZZ(ssss)
 		if err != nil {
@@ -293,152 +297,286 @@ func (d *Decoder) decodeACs(ht *hufftable) (block, error) {
 	return zz, nil
 }
 
-func levelShift(p uint8, a mat.Matrix) mat.Matrix {
-	s := math.Pow(2, float64(p-1))
+const blockSize = 64
 
-	var ret mat.Dense
-	ret.Apply(func(i, j int, v float64) float64 {
-		v = math.Trunc(v)
-		if v <= (-s) {
-			return 0
-		} else if v >= s {
-			return s*2 - 1
-		}
-		return v + s
-	}, a)
+type block [blockSize]int32
 
-	return &ret
+// reconstruct dequantizes and transforms all blocks of the component into samples
+// with the IDCT of the method. The differences of a differential frame are not
+// level shifted, and stored in two's complement.
+func (p *frameComponentParam) reconstruct(prec uint8, differential bool, m DCTMethod) error {
+	idct, t, err := p.idct(prec, m)
+	if err != nil {
+		return err
+	}
+
+	p.allocPix()
+	p.reconstructRows(idct, t, prec, differential, 0, p.bh)
+
+	return nil
 }
 
-const blockSize = 64
+// idct returns the IDCT of the method and its table for the quantization table
+// of the component.
+func (p *frameComponentParam) idct(prec uint8, m DCTMethod) (idctFunc, *idctTable, error) {
+	if p.qt == nil {
+		return nil, nil, errors.New("quantization table not found")
+	}
+
+	idct, err := idctMethod(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return idct, newIDCTTable(m, &p.qt.qs, prec), nil
+}
 
-type block [blockSize]int16
+// allocPix allocates the samples of all blocks of the component.
+func (p *frameComponentParam) allocPix() {
+	p.stride = p.bw * 8
+	p.pix = make([]uint16, p.stride*p.bh*8)
+}
 
-func reconstruct(a mat.Matrix) block {
-	var ret block
-	for y := 0; y < 8; y++ {
-		for x := 0; x < 8; x++ {
-			v := a.At(y, x)
-			ret[y*8+x] = int16(v)
+// reconstructRows transforms the blocks of the block rows [by0, by1) into samples.
+func (p *frameComponentParam) reconstructRows(idct idctFunc, t *idctTable, prec uint8, differential bool, by0, by1 int) {
+	stride := p.stride
+	for by := by0; by < by1; by++ {
+		for bx := 0; bx < p.bw; bx++ {
+			var in, out block
+			zz := p.block(bx, by)
+			for i, k := range unzig {
+				in[i] = zz[k]
+			}
+			idct(&in, t, &out, prec, differential)
+			for y := 0; y < 8; y++ {
+				for x, v := range out[y*8 : y*8+8] {
+					p.pix[(by*8+y)*stride+bx*8+x] = uint16(v)
+				}
+			}
 		}
 	}
-	return ret
 }
 
-func (d *Decoder) decodeDataUnit(param *componentParam, p uint8) (block, error) {
+// dcValue returns the DC coefficient of the decoded difference, which is predicted
+// from the previous block of the component except in differential frames.
+func (d *Decoder) dcValue(cs uint8, diff int32) int32 {
+	if d.differential {
+		return diff
+	}
+
+	d.pred[cs] += diff
+	return d.pred[cs]
+}
+
+func (d *Decoder) decodeDataUnit(param *componentParam, zz *block) error {
 	dc, err := d.decodeDC(param.dcHT)
 	if err != nil {
-		return block{}, err
+		return err
 	}
-	d.pred[param.cs] += dc
-
-	zz, err := d.decodeACs(param.acHT)
+	acs, err := d.decodeACs(param.acHT)
 	if err != nil {
-		return block{}, err
+		return err
 	}
-	zz[0] = d.pred[param.cs]
+	*zz = acs
+	zz[0] = d.dcValue(param.cs, dc)
 
-	ret := reconstruct(levelShift(p, idct_(zzToMatrix(param.qt.Unquantize(zz)))))
-	return ret, nil
+	return nil
 }
 
-func (d *Decoder) decodeMCU(params []*componentParam, p uint8) ([][]block, error) {
-	ret := make([][]block, len(params))
-	param := params[0]
-	for i, cidx, b := 0, 0, 0; true; i++ {
-		if param.nunit <= i-b {
-			cidx++
-			if cidx >= len(params) {
-				break
-			}
+func (d *Decoder) decodeUnit(h *scanHeader, param *componentParam, zz *block) error {
+	if d.arithmetic {
+		return d.decodeArithUnit(h, param, zz)
+	}
 
-			b += param.nunit
-			param = params[cidx]
-		}
+	if !d.progressive {
+		return d.decodeDataUnit(param, zz)
+	}
 
-		unit, err := d.decodeDataUnit(param, p)
-		if err != nil {
-			return nil, err
-		}
+	switch {
+	case h.ss == 0 && h.ah == 0:
+		return d.decodeDCFirst(param, h.al, zz)
+	case h.ss == 0:
+		return d.decodeDCRefine(h.al, zz)
+	case h.ah == 0:
+		return d.decodeACFirst(param, h, zz)
+	default:
+		return d.decodeACRefine(param, h, zz)
+	}
+}
 
-		ret[cidx] = append(ret[cidx], unit)
+func (d *Decoder) decodeMCU(frameHeader *frameHeader, scanHeader *scanHeader, params []*componentParam, n int) error {
+	if frameHeader.isLossless() {
+		return d.decodeLosslessMCU(frameHeader, scanHeader, params, n)
 	}
 
-	return ret, nil
+	if len(params) == 1 {
+		// non-interleave
+		param := params[0]
+		nx := padding(8, int(param.x)) / 8
+		return d.decodeUnit(scanHeader, param, param.fp.block(n%nx, n/nx))
+	}
+
+	mx, my := n%frameHeader.mcuX, n/frameHeader.mcuX
+	for _, param := range params {
+		for i := 0; i < int(param.v); i++ {
+			for j := 0; j < int(param.h); j++ {
+				zz := param.fp.block(mx*int(param.h)+j, my*int(param.v)+i)
+				if err := d.decodeUnit(scanHeader, param, zz); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
 }
 
-func (d *Decoder) decodeRestartInterval(params []*componentParam, nmcu int, ri int, p uint8) ([][]block, error) {
-	d.pred = make(map[uint8]int16)
+func (d *Decoder) decodeRestartInterval(
+	frameHeader *frameHeader,
+	scanHeader *scanHeader,
+	params []*componentParam,
+	begin, nmcu int,
+) error {
+	d.pred = make(map[uint8]int32)
+	d.eobrun = 0
+	d.resetBits()
+	if d.arithmetic {
+		d.resetArith(scanHeader, params)
+	}
 
-	var cnt, rst int
-	ret := make([][]block, len(params))
-	for i := 0; i < nmcu; i++ {
-		units, err := d.decodeMCU(params, p)
-		if err != nil {
-			return nil, err
-		}
+	if frameHeader.isLossless() {
+		startLosslessInterval(frameHeader, params, begin)
+	}
 
-		for i, mcu := range units {
-			ret[i] = append(ret[i], mcu...)
+	for i := begin; i < begin+nmcu; i++ {
+		if err := d.decodeMCU(frameHeader, scanHeader, params, i); err != nil {
+			return err
 		}
+		if d.mcuDone != nil {
+			d.mcuDone(i)
+		}
+	}
 
-		cnt++
-		if cnt == ri {
-			d.pred = make(map[uint8]int16)
+	return nil
+}
 
-			m, err := d.readMarker()
-			if err != nil {
-				return nil, err
-			}
+func (d *Decoder) readRST(rst int) error {
+	d.resetBits()
+	if d.arithmetic {
+		if err := d.skipToMarker(); err != nil {
+			return err
+		}
+	}
 
-			rst1 := m.RST()
-			if rst1 == -1 {
-				return nil, ErrUnexpectedMarker
-			}
+	m, err := d.readMarker()
+	if err != nil {
+		return err
+	}
 
-			if rst != rst1 {
-				return nil, errors.New("Invalid reset marker")
-			}
+	rst1 := m.RST()
+	if rst1 == -1 {
+		return ErrUnexpectedMarker
+	}
 
-			cnt = 0
-			rst++
-			if rst == 8 {
-				rst = 0
-			}
-		}
+	if rst != rst1 {
+		return errors.New("Invalid reset marker")
 	}
 
-	return ret, nil
+	return nil
 }
 
-func (d *Decoder) decodeScan(frameHeader *frameHeader, misc *miscTables) (map[uint8][]block, error) {
+func (d *Decoder) decodeScan(frameHeader *frameHeader, misc *miscTables) error {
 	scanHeader, err := d.decodeScanHeader()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	params, nmcu, err := getComponentParams(frameHeader, misc.quantizationTables, misc.hufftables, scanHeader)
+	params, nmcu, err := getComponentParams(frameHeader, misc.quantizationTables, misc.hufftables, misc.conditioningTables, scanHeader)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	slog.Info("decode scan",
 		"header", scanHeader,
 	)
 
-	ret := make(map[uint8][]block)
-	for {
-		components, err := d.decodeRestartInterval(params, nmcu, misc.interval, frameHeader.p)
-		if err == ErrUnexpectedMarker {
-			d.unread()
-			return ret, nil
-		} else if err == EOS {
-			return ret, nil
-		} else if err != nil {
-			return nil, err
+	d.progressive = frameHeader.isProgressive()
+	d.arithmetic = frameHeader.isArithmetic()
+	d.differential = frameHeader.isDifferential()
+	if d.progressive {
+		if err := validateProgressiveScan(scanHeader); err != nil {
+			return err
+		}
+	}
+	if frameHeader.isLossless() {
+		if err := validateLosslessScan(frameHeader, scanHeader, d.differential); err != nil {
+			return err
 		}
 
-		for i, param := range params {
-			ret[param.cs] = append(ret[param.cs], components[i]...)
+		if d.arithmetic {
+			for _, param := range params {
+				param.diffs = make([]int32, len(param.fp.pix))
+			}
 		}
 	}
+
+	for _, param := range params {
+		if param.fp.qt == nil {
+			param.fp.qt = param.qt
+		}
+	}
+
+	ri := misc.interval
+	if ri <= 0 {
+		ri = nmcu
+	}
+
+	// the samples of a lossless interval are predicted from the previous intervals
+	if d.opts.RestartWorkers > 1 && ri < nmcu && !frameHeader.isLossless() {
+		return d.decodeRestartIntervals(frameHeader, scanHeader, params, nmcu, ri)
+	}
+
+	if d.opts.PipelineWorkers > 1 && d.pipelined(frameHeader, scanHeader) {
+		err = d.decodePipeline(frameHeader, params, func() error {
+			return d.decodeIntervals(frameHeader, scanHeader, params, nmcu, ri)
+		})
+	} else {
+		err = d.decodeIntervals(frameHeader, scanHeader, params, nmcu, ri)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.resetBits()
+	if d.arithmetic {
+		return d.skipToMarker()
+	}
+
+	return nil
+}
+
+// decodeIntervals decodes the restart intervals of ri MCUs of the scan in order.
+func (d *Decoder) decodeIntervals(
+	frameHeader *frameHeader,
+	scanHeader *scanHeader,
+	params []*componentParam,
+	nmcu, ri int,
+) error {
+	for i, rst := 0, 0; i < nmcu; i += ri {
+		if i != 0 {
+			if err := d.readRST(rst); err != nil {
+				return err
+			}
+
+			rst++
+			if rst == 8 {
+				rst = 0
+			}
+		}
+
+		if err := d.decodeRestartInterval(frameHeader, scanHeader, params, i, min(ri, nmcu-i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
@@ -1,6 +1,7 @@
 package decoder
 
 import (
+	"bytes"
 	"math"
 	"testing"
 
@@ -69,16 +70,25 @@ func BenchmarkPow_Shift(b *testing.B) {
 func TestExtend(t *testing.T) {
 	exp := []int16{-3, -2, 2, 3}
 	for i := 0; i < 4; i++ {
-		v := extend(uint8(i), 2)
+		v := extend(uint16(i), 2)
 		if exp[i] != v {
 			t.Errorf("extend(%v,2)=%08b %v", i, v, int8(v))
 		}
 	}
 }
 
+func zzToMatrix(zz block) *mat.Dense {
+	var data [blockSize]float64
+	for i, n := range unzig {
+		data[i] = float64(zz[n])
+	}
+
+	return mat.NewDense(8, 8, data[:])
+}
+
 func TestZZMatrix(t *testing.T) {
 	var zz block
-	for i := int16(0); i < 64; i++ {
+	for i := int32(0); i < 64; i++ {
 		zz[i] = i
 	}
 
@@ -108,3 +118,20 @@ func TestPadding_5(t *testing.T) {
 	}
 	t.Errorf("exp=%v act=%v", 8, v)
 }
+
+func TestDecodeACs_tooManyCoefficients(t *testing.T) {
+	// the runs of the single 1-bit code run past the end of the block
+	for _, rs := range []uint8{0xF0, 0xF1} {
+		var bits [16]uint8
+		bits[0] = 1
+		ht, err := makeHufftable(1, 0, bits, []*huffval{{v: rs}})
+		if err != nil {
+			t.Fatalf("makeHufftable: %v", err)
+		}
+
+		d := New(bytes.NewReader(make([]byte, 8)))
+		if _, err := d.decodeACs(ht); err == nil {
+			t.Errorf("RS=0x%02X: no error", rs)
+		}
+	}
+}
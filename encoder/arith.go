@@ -0,0 +1,436 @@
+package encoder
+
+import (
+	"errors"
+
+	"github.com/yunomu/jpeg/decoder"
+	"github.com/yunomu/jpeg/jpeg"
+)
+
+const fixedBin = 113
+
+var ErrInvalidConditioning = errors.New("invalid arithmetic conditioning")
+
+// Conditioning is the conditioning of the arithmetic coding of the DC and AC
+// coefficients (F.1.4.4.1.4, F.1.4.4.2.1).
+type Conditioning struct {
+	L, U uint8 // bounds of the DC difference categories, L <= U <= 15
+	Kx   uint8 // boundary of the AC coefficient bands from 1 to 63
+}
+
+var defaultConditioning = Conditioning{L: 0, U: 1, Kx: 5}
+
+func (c *Conditioning) validate() error {
+	if c.L > c.U || c.U > 15 || c.Kx < 1 || c.Kx > 63 {
+		return ErrInvalidConditioning
+	}
+	return nil
+}
+
+// arithEncoder is the state of the arithmetic encoder of Annex D,
+// the same as the encoder of libjpeg.
+type arithEncoder struct {
+	c, a   int64
+	sc, zc int // number of stacked 0xFF bytes and pending zero bytes
+	ct     int
+	buffer int // buffered byte, -1 if none
+
+	dcStats, acStats [4][256]uint8
+	fixed            uint8
+
+	cond Conditioning
+}
+
+// resetArith initializes the encoder and the statistics of the scan.
+func (e *Encoder) resetArith(s *scan) {
+	ae := &e.arith
+	ae.c = 0
+	ae.a = 0x10000
+	ae.sc, ae.zc = 0, 0
+	ae.ct = 11
+	ae.buffer = -1
+	ae.fixed = fixedBin
+
+	for _, c := range s.comps {
+		c.dcContext = 0
+		if !e.progressive || (s.ss == 0 && s.ah == 0) {
+			ae.dcStats[c.dc.target&3] = [256]uint8{}
+		}
+		if !e.progressive || s.ss != 0 {
+			ae.acStats[c.ac.target&3] = [256]uint8{}
+		}
+	}
+}
+
+func (e *Encoder) arithEmitZeros() {
+	for ; e.arith.zc > 0; e.arith.zc-- {
+		e.writeByte(0)
+	}
+}
+
+func (e *Encoder) arithEmit(b int) {
+	e.writeByte(byte(b))
+	if b == 0xFF {
+		e.writeByte(0)
+	}
+}
+
+// arithOut outputs the byte of the code register with the carry (D.1.6).
+func (e *Encoder) arithOut(temp int) {
+	ae := &e.arith
+	if temp > 0xFF {
+		// carry
+		if ae.buffer >= 0 {
+			e.arithEmitZeros()
+			e.arithEmit(ae.buffer + 1)
+		}
+		ae.zc += ae.sc
+		ae.sc = 0
+		ae.buffer = temp & 0xFF
+		return
+	}
+
+	if temp == 0xFF {
+		ae.sc++
+		return
+	}
+
+	if ae.buffer == 0 {
+		ae.zc++
+	} else if ae.buffer >= 0 {
+		e.arithEmitZeros()
+		e.arithEmit(ae.buffer)
+	}
+	if ae.sc > 0 {
+		e.arithEmitZeros()
+		for ; ae.sc > 0; ae.sc-- {
+			e.writeByte(0xFF)
+			e.writeByte(0)
+		}
+	}
+	ae.buffer = temp & 0xFF
+}
+
+// arithEncode encodes the binary decision with the statistics bin st (D.1.2).
+func (e *Encoder) arithEncode(st *uint8, val int) {
+	ae := &e.arith
+	sv := int(*st)
+	q := jpeg.QeTable[sv&0x7F]
+	nl := uint8(q)
+	nm := uint8(q >> 8)
+	qe := int64(q >> 16)
+
+	ae.a -= qe
+	if val != sv>>7 {
+		// LPS
+		if ae.a >= qe {
+			ae.c += ae.a
+			ae.a = qe
+		}
+		*st = uint8(sv&0x80) ^ nl
+	} else {
+		// MPS
+		if ae.a >= 0x8000 {
+			return
+		}
+		if ae.a < qe {
+			ae.c += ae.a
+			ae.a = qe
+		}
+		*st = uint8(sv&0x80) ^ nm
+	}
+
+	// renormalization
+	for {
+		ae.a <<= 1
+		ae.c <<= 1
+		ae.ct--
+		if ae.ct == 0 {
+			e.arithOut(int(ae.c >> 19))
+			ae.c &= 0x7FFFF
+			ae.ct += 8
+		}
+		if ae.a >= 0x8000 {
+			break
+		}
+	}
+}
+
+// finishArith flushes the code register at the end of the scan or the restart interval (D.1.8).
+func (e *Encoder) finishArith() {
+	ae := &e.arith
+
+	temp := (ae.a - 1 + ae.c) & 0xFFFF0000
+	if temp < ae.c {
+		ae.c = temp + 0x8000
+	} else {
+		ae.c = temp
+	}
+	ae.c <<= ae.ct
+
+	if ae.c&0xF8000000 != 0 {
+		e.arithOut(0x100)
+	} else {
+		e.arithOut(0)
+	}
+	// the final bytes are output only if they are not 0x00
+	if ae.c&0x7FFF800 != 0 {
+		e.arithEmitZeros()
+		e.arithEmit(int(ae.c>>19) & 0xFF)
+		if ae.c&0x7F800 != 0 {
+			e.arithEmit(int(ae.c>>11) & 0xFF)
+		}
+	}
+}
+
+// writeArithRST terminates the restart interval, writes the RST marker and
+// resets the statistics for the next interval (F.1.4.4.1.1).
+func (e *Encoder) writeArithRST(n int, s *scan) {
+	e.finishArith()
+	e.writeMarker(decoder.Marker_RST_0 + decoder.Marker(n%8))
+	e.resetArith(s)
+	for _, c := range s.comps {
+		c.pred = 0
+	}
+}
+
+// arithEncodeMagnitude encodes the rest of the magnitude category of v from m
+// with the X bins in xs (Figure F.8), followed by its bit pattern with the M bin
+// 14 bins after the last X bin (Figure F.9).
+func (e *Encoder) arithEncodeMagnitude(m int32, xs []uint8, v int32) {
+	xi := 0
+	for m<<1 <= v {
+		e.arithEncode(&xs[xi], 1)
+		m <<= 1
+		xi++
+	}
+	e.arithEncode(&xs[xi], 0)
+
+	st := &xs[xi+14]
+	for m >>= 1; m != 0; m >>= 1 {
+		b := 0
+		if v&m != 0 {
+			b = 1
+		}
+		e.arithEncode(st, b)
+	}
+}
+
+// arithEncodeDC encodes the DC difference (F.1.4.1).
+func (e *Encoder) arithEncodeDC(c *component, diff int32) {
+	stats := e.arith.dcStats[c.dc.target&3][:]
+	s0 := c.dcContext
+
+	if diff == 0 {
+		e.arithEncode(&stats[s0], 0)
+		c.dcContext = 0
+		return
+	}
+	e.arithEncode(&stats[s0], 1)
+
+	v := diff
+	sign := 0
+	if v < 0 {
+		v = -v
+		sign = 1
+	}
+	e.arithEncode(&stats[s0+1], sign)
+
+	if v == 1 {
+		e.arithEncode(&stats[s0+2+sign], 0)
+	} else {
+		e.arithEncode(&stats[s0+2+sign], 1)
+		e.arithEncodeMagnitude(1, stats[20:], v-1)
+	}
+
+	// conditioning category of the next difference (F.1.4.4.1.2)
+	cond := e.arith.cond
+	switch {
+	case v <= (1<<cond.L)>>1:
+		c.dcContext = 0
+	case v > 1<<cond.U:
+		c.dcContext = 4 * (3 + sign)
+	default:
+		c.dcContext = 4 * (1 + sign)
+	}
+}
+
+// arithEncodeAC encodes the AC coefficients of the spectral band from ss to se,
+// which are transformed by transform (F.1.4.2).
+func (e *Encoder) arithEncodeAC(c *component, zz *[64]int32, ss, se int, transform func(int32) int32) {
+	stats := e.arith.acStats[c.ac.target&3][:]
+
+	ke := se
+	for ; ke > 0; ke-- {
+		if transform(zz[ke]) != 0 {
+			break
+		}
+	}
+
+	k := ss
+	for ; k <= ke; k++ {
+		st := 3 * (k - 1)
+		e.arithEncode(&stats[st], 0) // EOB decision
+
+		v := transform(zz[k])
+		for v == 0 {
+			e.arithEncode(&stats[st+1], 0)
+			st += 3
+			k++
+			v = transform(zz[k])
+		}
+		e.arithEncode(&stats[st+1], 1)
+
+		if v < 0 {
+			v = -v
+			e.arithEncode(&e.arith.fixed, 1)
+		} else {
+			e.arithEncode(&e.arith.fixed, 0)
+		}
+
+		// X1 and X2 share the bin after S0
+		st += 2
+		if v == 1 {
+			e.arithEncode(&stats[st], 0)
+			continue
+		}
+		e.arithEncode(&stats[st], 1)
+		if v == 2 {
+			e.arithEncode(&stats[st], 0)
+			continue
+		}
+		e.arithEncode(&stats[st], 1)
+
+		xs := stats[217:]
+		if k <= int(e.arith.cond.Kx) {
+			xs = stats[189:]
+		}
+		e.arithEncodeMagnitude(2, xs, v-1)
+	}
+
+	if k <= se {
+		e.arithEncode(&stats[3*(k-1)], 1)
+	}
+}
+
+func identity(v int32) int32 {
+	return v
+}
+
+// pointTransform returns the function which divides the AC coefficient by 2^al,
+// rounding towards zero.
+func pointTransform(al uint8) func(int32) int32 {
+	return func(v int32) int32 {
+		if v < 0 {
+			return -(-v >> al)
+		}
+		return v >> al
+	}
+}
+
+func (e *Encoder) arithEncodeBlock(c *component, zz *[64]int32) {
+	diff := zz[0] - c.pred
+	c.pred = zz[0]
+	e.arithEncodeDC(c, diff)
+	e.arithEncodeAC(c, zz, 1, 63, identity)
+}
+
+func (e *Encoder) arithEncodeDCFirst(c *component, zz *[64]int32, al uint8) {
+	dc := zz[0] >> al
+	diff := dc - c.pred
+	c.pred = dc
+	e.arithEncodeDC(c, diff)
+}
+
+func (e *Encoder) arithEncodeDCRefine(zz *[64]int32, al uint8) {
+	e.arithEncode(&e.arith.fixed, int(zz[0]>>al)&1)
+}
+
+// arithEncodeACRefine encodes the refinement of the AC coefficients (G.1.3.3).
+func (e *Encoder) arithEncodeACRefine(c *component, zz *[64]int32, ss, se int, ah, al uint8) {
+	stats := e.arith.acStats[c.ac.target&3][:]
+	cur, prev := pointTransform(al), pointTransform(ah)
+
+	ke := se
+	for ; ke > 0; ke-- {
+		if cur(zz[ke]) != 0 {
+			break
+		}
+	}
+	// end of block in the previous stage
+	kex := ke
+	for ; kex > 0; kex-- {
+		if prev(zz[kex]) != 0 {
+			break
+		}
+	}
+
+	k := ss
+	for ; k <= ke; k++ {
+		st := 3 * (k - 1)
+		if k > kex {
+			e.arithEncode(&stats[st], 0) // EOB decision
+		}
+
+		for {
+			v := cur(zz[k])
+			if v != 0 {
+				sign := 0
+				if v < 0 {
+					v = -v
+					sign = 1
+				}
+
+				if v>>1 != 0 {
+					// correction bit of a previously nonzero coefficient
+					e.arithEncode(&stats[st+2], int(v&1))
+				} else {
+					// newly nonzero coefficient
+					e.arithEncode(&stats[st+1], 1)
+					e.arithEncode(&e.arith.fixed, sign)
+				}
+				break
+			}
+
+			e.arithEncode(&stats[st+1], 0)
+			st += 3
+			k++
+		}
+	}
+
+	if k <= se {
+		e.arithEncode(&stats[3*(k-1)], 1)
+	}
+}
+
+// encodeArithUnit encodes the data unit of the component in the scan.
+func (e *Encoder) encodeArithUnit(s *scan, c *component, zz *[64]int32) {
+	switch {
+	case !e.progressive:
+		e.arithEncodeBlock(c, zz)
+	case s.ss == 0 && s.ah == 0:
+		e.arithEncodeDCFirst(c, zz, s.al)
+	case s.ss == 0:
+		e.arithEncodeDCRefine(zz, s.al)
+	case s.ah == 0:
+		e.arithEncodeAC(c, zz, int(s.ss), int(s.se), pointTransform(s.al))
+	default:
+		e.arithEncodeACRefine(c, zz, int(s.ss), int(s.se), s.ah, s.al)
+	}
+}
+
+// writeDAC writes the conditioning of the DC and AC tables of the frame.
+func (e *Encoder) writeDAC(h *frame) {
+	cond := e.arith.cond
+
+	var data []byte
+	for _, ht := range h.hufftables() {
+		if ht.class == 0 {
+			data = append(data, ht.target, cond.U<<4|cond.L)
+		} else {
+			data = append(data, 1<<4|ht.target, cond.Kx)
+		}
+	}
+
+	e.writeSegment(decoder.Marker_DAC, data)
+}
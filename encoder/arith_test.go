@@ -0,0 +1,71 @@
+package encoder
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yunomu/jpeg/decoder"
+)
+
+func TestEncode_arithmetic(t *testing.T) {
+	src := testImage(45, 27)
+	cond := &Conditioning{L: 2, U: 5, Kx: 20}
+
+	for _, c := range []struct {
+		name string
+		opts Options
+		sof  byte
+	}{
+		{"sequential", Options{}, 0xC9},
+		{"restart", Options{RestartInterval: 3}, 0xC9},
+		{"conditioning", Options{Conditioning: cond, Subsampling: Subsampling444}, 0xC9},
+		{"progressive", Options{Progressive: true}, 0xCA},
+		{"progressive restart", Options{Progressive: true, RestartInterval: 2, Conditioning: cond}, 0xCA},
+	} {
+		huff := encode(t, src, &c.opts)
+
+		opts := c.opts
+		opts.Arithmetic = true
+		arith := encode(t, src, &opts)
+		if !bytes.Contains(arith, []byte{0xFF, c.sof}) {
+			t.Errorf("%s: no SOF %X", c.name, c.sof)
+		}
+		if bytes.Contains(arith, []byte{0xFF, 0xC4}) {
+			t.Errorf("%s: DHT in arithmetic coded image", c.name)
+		}
+		if dac := bytes.Contains(arith, []byte{0xFF, 0xCC}); dac != (c.opts.Conditioning != nil) {
+			t.Errorf("%s: DAC=%v", c.name, dac)
+		}
+
+		// the coefficients are the same as the Huffman coded image
+		exp, err := decoder.Decode(bytes.NewReader(huff))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		act, err := decoder.Decode(bytes.NewReader(arith))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		if e := meanError(t, exp, act); e != 0 {
+			t.Errorf("%s: mean error=%v", c.name, e)
+		}
+	}
+}
+
+func TestEncode_arithmeticInvalid(t *testing.T) {
+	src := testImage(8, 8)
+
+	for _, c := range []struct {
+		opts Options
+		err  error
+	}{
+		{Options{Arithmetic: true, Conditioning: &Conditioning{L: 2, U: 1, Kx: 5}}, ErrInvalidConditioning},
+		{Options{Arithmetic: true, Conditioning: &Conditioning{L: 0, U: 16, Kx: 5}}, ErrInvalidConditioning},
+		{Options{Arithmetic: true, Conditioning: &Conditioning{L: 0, U: 1, Kx: 0}}, ErrInvalidConditioning},
+		{Options{Arithmetic: true, Lossless: true}, ErrUnsupportedOptions},
+	} {
+		if err := Encode(&bytes.Buffer{}, src, &c.opts); err != c.err {
+			t.Errorf("%+v: err=%v exp=%v", c.opts, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+package encoder
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"log/slog"
+
+	"github.com/yunomu/jpeg/decoder"
+	"github.com/yunomu/jpeg/lib/delta"
+	"github.com/yunomu/jpeg/lib/huffman"
+)
+
+// Subsampling is the chroma subsampling of a YCbCr image.
+type Subsampling int
+
+const (
+	Subsampling420 Subsampling = iota
+	Subsampling422
+	Subsampling444
+)
+
+func (s Subsampling) String() string {
+	switch s {
+	case Subsampling420:
+		return "4:2:0"
+	case Subsampling422:
+		return "4:2:2"
+	case Subsampling444:
+		return "4:4:4"
+	}
+	return "unknown"
+}
+
+// factors returns the sampling factors of the luminance component.
+func (s Subsampling) factors() (uint8, uint8, error) {
+	switch s {
+	case Subsampling420:
+		return 2, 2, nil
+	case Subsampling422:
+		return 2, 1, nil
+	case Subsampling444:
+		return 1, 1, nil
+	}
+	return 0, 0, ErrInvalidSubsampling
+}
+
+const DefaultQuality = 75
+
+// Options are the encoding parameters. The zero value encodes a 4:2:0 JFIF
+// image with DefaultQuality.
+type Options struct {
+	// Quality is the IJG quality factor from 1 to 100 which scales the tables of Annex K.
+	// 0 means DefaultQuality.
+	Quality int
+
+	// ChromaQuality is the quality factor of the chrominance table. 0 means Quality.
+	ChromaQuality int
+
+	// TableSet is the set of the base tables scaled by the quality factors.
+	TableSet TableSet
+
+	Subsampling Subsampling
+
+	// LumaTable and ChromaTable replace the tables scaled by the quality factors if not nil.
+	// The values are in natural (row-major) order.
+	LumaTable, ChromaTable *[64]uint16
+
+	// RestartInterval is the number of MCUs in a restart interval. 0 disables restart markers.
+	RestartInterval int
+
+	// OptimizeHuffman builds the Huffman tables from the statistics of the image
+	// in an extra pass instead of using the tables of Annex K.3.
+	OptimizeHuffman bool
+
+	// Trellis quantizes the AC coefficients to minimize the sum of the rate estimated
+	// with the Huffman tables and the distortion weighted by TrellisLambda, as mozjpeg.
+	Trellis bool
+
+	// TrellisDC also quantizes the DC coefficients with trellis quantization.
+	TrellisDC bool
+
+	// TrellisLambda is the base 2 logarithm of the weight of the distortion.
+	// Larger values keep more detail. 0 means DefaultTrellisLambda.
+	TrellisLambda float64
+
+	// Progressive encodes a progressive image with the scan script of Scans.
+	// The Huffman tables are always optimized for each scan.
+	Progressive bool
+
+	// Scans is the scan script of a progressive image. nil means SimpleProgression.
+	Scans []Scan
+
+	// Arithmetic codes the image with arithmetic coding instead of Huffman coding,
+	// as SOF9 or SOF10. It is not supported for lossless images.
+	Arithmetic bool
+
+	// Conditioning is the conditioning of arithmetic coding written in a DAC segment.
+	// nil means the default conditioning, which needs no DAC segment.
+	Conditioning *Conditioning
+
+	// Lossless encodes a lossless image with Predictor, PointTransform and Precision.
+	// The samples of a color image are coded as RGB without color conversion.
+	Lossless bool
+
+	// Predictor is the predictor of a lossless image from 1 to 7. 0 means 1.
+	Predictor delta.Predictor
+
+	// PointTransform is the number of the low bits of the samples discarded in a lossless image.
+	PointTransform uint8
+
+	// Precision is the number of bits of the samples, 8 or 12 for DCT-based images
+	// and from 2 to 16 for lossless images. 0 means 12 (16 if lossless) for images
+	// with 16-bit color models, and 8 for others. 12-bit images are extended
+	// sequential (SOF1) or progressive with optimized Huffman tables.
+	Precision uint8
+
+	// MaxSize is the maximum size of the output in bytes. If positive, the highest
+	// quality with the output within MaxSize is searched instead of Quality.
+	MaxSize int
+
+	// TargetPSNR is the PSNR of the samples in dB to reach. If positive, the lowest
+	// quality with the PSNR at least TargetPSNR is searched instead of Quality,
+	// limited by MaxSize. ChromaQuality keeps its difference from Quality in the search.
+	TargetPSNR float64
+
+	// DCTMethod is the implementation of the forward DCT. The zero value is DCTISlow.
+	DCTMethod DCTMethod
+
+	// OmitJFIF omits the JFIF APP0 segment.
+	OmitJFIF bool
+
+	// Comment is written in a COM segment if not empty.
+	Comment string
+}
+
+var (
+	ErrInvalidQuality           = errors.New("invalid quality")
+	ErrInvalidSubsampling       = errors.New("invalid subsampling")
+	ErrInvalidQuantizationTable = errors.New("invalid quantization table")
+	ErrInvalidRestartInterval   = errors.New("invalid restart interval")
+	ErrInvalidImageSize         = errors.New("invalid image size")
+	ErrCommentTooLong           = errors.New("comment too long")
+	ErrUnsupportedOptions       = errors.New("unsupported combination of options")
+)
+
+type Encoder struct {
+	w    *bufio.Writer
+	opts Options
+
+	bits     uint64
+	nbits    uint8
+	counting bool // gathers the statistics of the symbols instead of writing them
+
+	progressive bool
+	eobrun      int
+	corrBits    []uint8 // correction bits of the blocks in the EOB run
+
+	arithmetic bool
+	arith      arithEncoder
+
+	result *SearchResult
+}
+
+func New(w io.Writer, opts *Options) *Encoder {
+	e := &Encoder{
+		w: bufio.NewWriter(w),
+	}
+	if opts != nil {
+		e.opts = *opts
+	}
+
+	return e
+}
+
+func (e *Encoder) writeByte(b byte) {
+	// write errors are sticky in bufio.Writer and returned by Flush
+	e.w.WriteByte(b)
+}
+
+func (e *Encoder) writeUint16(v uint16) {
+	e.writeByte(byte(v >> 8))
+	e.writeByte(byte(v))
+}
+
+func (e *Encoder) writeMarker(m decoder.Marker) {
+	e.writeByte(decoder.Marker_Prefix)
+	e.writeByte(byte(m))
+}
+
+// writeSegment writes a marker segment whose length includes its own two bytes.
+func (e *Encoder) writeSegment(m decoder.Marker, data []byte) {
+	e.writeMarker(m)
+	e.writeUint16(uint16(len(data) + 2))
+	e.w.Write(data)
+}
+
+func (e *Encoder) writeJFIF() {
+	e.writeSegment(decoder.Marker_APP_n, []byte{
+		'J', 'F', 'I', 'F', 0,
+		1, 1, // version 1.01
+		0,    // no units, aspect ratio only
+		0, 1, // Xdensity
+		0, 1, // Ydensity
+		0, 0, // no thumbnail
+	})
+}
+
+func (e *Encoder) writeCOM() error {
+	if len(e.opts.Comment) > 0xFFFF-2 {
+		return ErrCommentTooLong
+	}
+
+	e.writeSegment(decoder.Marker_COM, []byte(e.opts.Comment))
+	return nil
+}
+
+func (e *Encoder) writeDQT(qts []*quantizationTable) {
+	var data []byte
+	for _, qt := range qts {
+		data = append(data, qt.pq<<4|qt.tq)
+		for _, q := range qt.qs {
+			if qt.pq != 0 {
+				data = append(data, byte(q>>8))
+			}
+			data = append(data, byte(q))
+		}
+	}
+
+	e.writeSegment(decoder.Marker_DQT, data)
+}
+
+func (e *Encoder) writeSOF(m decoder.Marker, h *frame) {
+	data := []byte{
+		h.p,
+		byte(h.y >> 8), byte(h.y),
+		byte(h.x >> 8), byte(h.x),
+		byte(len(h.comps)),
+	}
+	for _, c := range h.comps {
+		var tq uint8
+		if c.qt != nil {
+			tq = c.qt.tq
+		}
+		data = append(data, c.c, c.h<<4|c.v, tq)
+	}
+
+	e.writeSegment(m, data)
+}
+
+func (e *Encoder) writeDHT(hts []*hufftable) {
+	var data []byte
+	for _, ht := range hts {
+		data = append(data, ht.class<<4|ht.target)
+		data = append(data, ht.table.Bits[:]...)
+		data = append(data, ht.table.Values...)
+	}
+
+	e.writeSegment(decoder.Marker_DHT, data)
+}
+
+func (e *Encoder) writeDRI(ri int) {
+	e.writeSegment(decoder.Marker_DRI, []byte{byte(ri >> 8), byte(ri)})
+}
+
+func (e *Encoder) writeSOS(comps []*component, ss, se, ah, al uint8) {
+	data := []byte{byte(len(comps))}
+	for _, c := range comps {
+		var ta uint8
+		if c.ac != nil {
+			ta = c.ac.target
+		}
+		data = append(data, c.c, c.dc.target<<4|ta)
+	}
+	data = append(data, ss, se, ah<<4|al)
+
+	e.writeSegment(decoder.Marker_SOS, data)
+}
+
+func isGray(m image.Image) bool {
+	switch m.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return true
+	}
+	return false
+}
+
+// optimizeHufftables replaces the Huffman tables with the ones optimized for
+// the symbols of the scan encoded by encode.
+func (e *Encoder) optimizeHufftables(hts []*hufftable, encode func()) error {
+	for _, ht := range hts {
+		ht.freq = huffman.Frequencies{}
+	}
+
+	e.counting = true
+	encode()
+	e.counting = false
+
+	for _, ht := range hts {
+		if err := ht.optimize(); err != nil {
+			return err
+		}
+		slog.Debug("optimized huffman table", "table", ht, "BITS", ht.table.Bits)
+	}
+
+	return nil
+}
+
+// writeTables writes the DAC segment if the conditioning is not the default,
+// or the DHT segment of the tables unless the image is arithmetic coded.
+func (e *Encoder) writeTables(h *frame, hts []*hufftable) {
+	if !e.arithmetic {
+		e.writeDHT(hts)
+	} else if e.arith.cond != defaultConditioning {
+		e.writeDAC(h)
+	}
+}
+
+func (e *Encoder) encodeSequential(h *frame) error {
+	s := &scan{comps: h.comps, se: 63}
+	// the tables of Annex K.3 have no codes for the magnitudes of 12-bit images
+	if (e.opts.OptimizeHuffman || h.p != 8) && !e.arithmetic {
+		err := e.optimizeHufftables(s.hufftables(false), func() {
+			e.encodeScan(h, s, e.opts.RestartInterval)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	e.writeDQT(h.quantizationTables())
+	sof := decoder.Marker_SOF0
+	switch {
+	case e.arithmetic:
+		sof = decoder.Marker_SOF9
+	case h.p != 8:
+		sof = decoder.Marker_SOF1
+	}
+	e.writeSOF(sof, h)
+	e.writeTables(h, h.hufftables())
+	if e.opts.RestartInterval > 0 {
+		e.writeDRI(e.opts.RestartInterval)
+	}
+	e.writeSOS(s.comps, s.ss, s.se, s.ah, s.al)
+	e.encodeScan(h, s, e.opts.RestartInterval)
+
+	return nil
+}
+
+func (e *Encoder) encodeProgressive(h *frame) error {
+	scans, err := progressiveScans(h, e.opts.Scans)
+	if err != nil {
+		return err
+	}
+
+	e.writeDQT(h.quantizationTables())
+	if e.arithmetic {
+		e.writeSOF(decoder.Marker_SOF10, h)
+		e.writeTables(h, nil)
+	} else {
+		e.writeSOF(decoder.Marker_SOF2, h)
+	}
+	if e.opts.RestartInterval > 0 {
+		e.writeDRI(e.opts.RestartInterval)
+	}
+
+	for _, s := range scans {
+		slog.Debug("encode scan", "scan", s)
+
+		if hts := s.hufftables(true); len(hts) != 0 && !e.arithmetic {
+			err := e.optimizeHufftables(hts, func() {
+				e.encodeScan(h, s, e.opts.RestartInterval)
+			})
+			if err != nil {
+				return err
+			}
+			e.writeDHT(hts)
+		}
+		e.writeSOS(s.comps, s.ss, s.se, s.ah, s.al)
+		e.encodeScan(h, s, e.opts.RestartInterval)
+	}
+
+	return nil
+}
+
+func (e *Encoder) newDCTFrame(m image.Image) (*frame, error) {
+	p := e.opts.Precision
+	if p == 0 {
+		p = 8
+		if is16(m) {
+			p = 12
+		}
+	}
+	if p != 8 && p != 12 {
+		return nil, ErrInvalidPrecision
+	}
+
+	luma, chroma, err := quantizationTables(&e.opts, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return newFrame(m, isGray(m), e.opts.Subsampling, p, e.opts.DCTMethod, luma, chroma)
+}
+
+// Encode writes the image as a sequential, progressive or lossless JPEG stream.
+func (e *Encoder) Encode(m image.Image) error {
+	b := m.Bounds()
+	if b.Dx() <= 0 || b.Dy() <= 0 || b.Dx() > 0xFFFF || b.Dy() > 0xFFFF {
+		return ErrInvalidImageSize
+	}
+
+	if e.opts.RestartInterval < 0 || e.opts.RestartInterval > 0xFFFF {
+		return ErrInvalidRestartInterval
+	}
+
+	e.result = nil
+	e.arithmetic = e.opts.Arithmetic
+	e.arith.cond = defaultConditioning
+	if e.opts.Conditioning != nil {
+		if err := e.opts.Conditioning.validate(); err != nil {
+			return err
+		}
+		e.arith.cond = *e.opts.Conditioning
+	}
+	if e.opts.Lossless && e.opts.Arithmetic {
+		return ErrUnsupportedOptions
+	}
+
+	var h *frame
+	var err error
+	if e.opts.Lossless {
+		h, err = newLosslessFrame(m, isGray(m), &e.opts)
+	} else {
+		h, err = e.newDCTFrame(m)
+	}
+	if err != nil {
+		return err
+	}
+
+	slog.Info("encode frame",
+		"header", h,
+		"quality", e.opts.Quality,
+		"tables", e.opts.TableSet,
+		"interval", e.opts.RestartInterval,
+		"progressive", e.opts.Progressive,
+		"lossless", e.opts.Lossless,
+		"arithmetic", e.opts.Arithmetic,
+		"trellis", e.opts.Trellis,
+	)
+	e.progressive = e.opts.Progressive && !e.opts.Lossless
+
+	if e.opts.MaxSize > 0 || e.opts.TargetPSNR > 0 {
+		if e.opts.Lossless || e.opts.LumaTable != nil || e.opts.ChromaTable != nil {
+			return ErrUnsupportedOptions
+		}
+		return e.encodeTarget(h)
+	}
+
+	if e.opts.Trellis && !e.opts.Lossless {
+		if err := e.trellisQuantize(h); err != nil {
+			return err
+		}
+	}
+
+	return e.encodeFrame(h)
+}
+
+// encodeFrame writes the stream of the frame of the quantized coefficients.
+func (e *Encoder) encodeFrame(h *frame) error {
+	var err error
+
+	e.writeMarker(decoder.Marker_SOI)
+	// JFIF is for YCbCr or grayscale images
+	if !e.opts.OmitJFIF && (!e.opts.Lossless || len(h.comps) == 1) {
+		e.writeJFIF()
+	}
+	if e.opts.Comment != "" {
+		if err := e.writeCOM(); err != nil {
+			return err
+		}
+	}
+	switch {
+	case e.opts.Lossless:
+		err = e.encodeLossless(h)
+	case e.progressive:
+		err = e.encodeProgressive(h)
+	default:
+		err = e.encodeSequential(h)
+	}
+	if err != nil {
+		return err
+	}
+	e.writeMarker(decoder.Marker_EOI)
+
+	return e.w.Flush()
+}
+
+// Encode writes the image to w as a JPEG stream.
+// A nil opts is the same as the zero value of Options.
+func Encode(w io.Writer, m image.Image, opts *Options) error {
+	return New(w, opts).Encode(m)
+}
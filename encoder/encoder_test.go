@@ -0,0 +1,355 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/yunomu/jpeg/decoder"
+)
+
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{
+				R: uint8(x * 255 / w),
+				G: uint8(y * 255 / h),
+				B: uint8((x + y) * 4),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// meanError returns the mean absolute difference of the RGB values of the images.
+func meanError(t *testing.T, a, b image.Image) float64 {
+	t.Helper()
+
+	if a.Bounds() != b.Bounds() {
+		t.Fatalf("bounds: %v != %v", a.Bounds(), b.Bounds())
+	}
+
+	abs := func(a, b uint32) int {
+		d := int(a>>8) - int(b>>8)
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+
+	bounds := a.Bounds()
+	sum := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r0, g0, b0, _ := a.At(x, y).RGBA()
+			r1, g1, b1, _ := b.At(x, y).RGBA()
+			sum += abs(r0, r1) + abs(g0, g1) + abs(b0, b1)
+		}
+	}
+
+	return float64(sum) / float64(3*bounds.Dx()*bounds.Dy())
+}
+
+func encode(t *testing.T, m image.Image, opts *Options) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, m, opts); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncode_subsampling(t *testing.T) {
+	src := testImage(37, 29)
+
+	for _, s := range []Subsampling{Subsampling444, Subsampling422, Subsampling420} {
+		data := encode(t, src, &Options{Quality: 90, Subsampling: s})
+
+		img, err := decoder.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%v: decoder.Decode: %v", s, err)
+		}
+		if e := meanError(t, src, img); e > 3 {
+			t.Errorf("%v: decoder: mean error=%v", s, e)
+		}
+
+		std, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%v: jpeg.Decode: %v", s, err)
+		}
+		if e := meanError(t, src, std); e > 3 {
+			t.Errorf("%v: image/jpeg: mean error=%v", s, e)
+		}
+	}
+}
+
+func TestEncode_gray(t *testing.T) {
+	src := image.NewGray(image.Rect(3, 5, 22, 17))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+
+	img, err := decoder.Decode(bytes.NewReader(encode(t, src, &Options{Quality: 100})))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	gray, ok := img.(*image.Gray)
+	if !ok {
+		t.Fatalf("unexpected image type: %T", img)
+	}
+
+	b := src.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			exp := int(src.GrayAt(b.Min.X+x, b.Min.Y+y).Y)
+			act := int(gray.GrayAt(x, y).Y)
+			if act < exp-2 || act > exp+2 {
+				t.Fatalf("(%d, %d): exp=%d act=%d", x, y, exp, act)
+			}
+		}
+	}
+}
+
+func TestEncode_quality(t *testing.T) {
+	src := testImage(64, 48)
+
+	var prevSize int
+	prevErr := 256.0
+	for _, q := range []int{10, 50, 95} {
+		data := encode(t, src, &Options{Quality: q})
+
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("quality %d: Decode: %v", q, err)
+		}
+
+		e := meanError(t, src, img)
+		if len(data) <= prevSize || e >= prevErr {
+			t.Errorf("quality %d: size=%d error=%v, previous size=%d error=%v", q, len(data), e, prevSize, prevErr)
+		}
+		prevSize, prevErr = len(data), e
+	}
+
+	if err := Encode(&bytes.Buffer{}, src, &Options{Quality: 101}); err != ErrInvalidQuality {
+		t.Errorf("quality 101: err=%v", err)
+	}
+}
+
+func TestEncode_restart(t *testing.T) {
+	// 5x3 MCUs of 4:2:0
+	src := testImage(80, 48)
+	data := encode(t, src, &Options{RestartInterval: 2})
+
+	var rsts []byte
+	for i := 0; i+1 < len(data); i++ {
+		if data[i] == 0xFF && data[i+1] >= 0xD0 && data[i+1] <= 0xD7 {
+			rsts = append(rsts, data[i+1])
+		}
+	}
+	if exp := []byte{0xD0, 0xD1, 0xD2, 0xD3, 0xD4, 0xD5, 0xD6}; !bytes.Equal(rsts, exp) {
+		t.Errorf("RST markers=%x exp=%x", rsts, exp)
+	}
+
+	img, err := decoder.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if e := meanError(t, src, img); e > 4 {
+		t.Errorf("mean error=%v", e)
+	}
+}
+
+func TestEncode_segments(t *testing.T) {
+	var luma [64]uint16
+	for i := range luma {
+		luma[i] = uint16(i + 1)
+	}
+
+	data := encode(t, testImage(8, 8), &Options{
+		LumaTable: &luma,
+		Comment:   "hello",
+	})
+
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 16, 'J', 'F', 'I', 'F', 0}) {
+		t.Errorf("no JFIF segment: %x", data[:16])
+	}
+	if !bytes.Contains(data, []byte{0xFF, 0xFE, 0, 7, 'h', 'e', 'l', 'l', 'o'}) {
+		t.Errorf("no COM segment")
+	}
+
+	// the first 8 values of the table in zigzag order
+	if !bytes.Contains(data, []byte{0xFF, 0xDB, 0, 132, 0, 1, 2, 9, 17, 10, 3, 4, 11}) {
+		t.Errorf("custom DQT not found")
+	}
+
+	data = encode(t, testImage(8, 8), &Options{OmitJFIF: true})
+	if !bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF, 0xDB}) {
+		t.Errorf("unexpected segment: %x", data[:4])
+	}
+
+	luma[3] = 256
+	if err := Encode(&bytes.Buffer{}, testImage(8, 8), &Options{LumaTable: &luma}); err != ErrInvalidQuantizationTable {
+		t.Errorf("err=%v", err)
+	}
+}
+
+func TestScaleTable(t *testing.T) {
+	if act := scaleTable(&stdLuma, 50, 255); act != stdLuma {
+		t.Errorf("quality 50: %v", act)
+	}
+
+	for i, q := range scaleTable(&stdChroma, 100, 255) {
+		if q != 1 {
+			t.Errorf("quality 100: Q[%d]=%d", i, q)
+		}
+	}
+
+	for i, q := range scaleTable(&stdLuma, 1, 255) {
+		if q != 255 {
+			t.Errorf("quality 1: Q[%d]=%d", i, q)
+		}
+	}
+}
+
+func TestEncode_optimizeHuffman(t *testing.T) {
+	src := testImage(96, 64)
+
+	for _, ri := range []int{0, 5} {
+		std := encode(t, src, &Options{RestartInterval: ri})
+		opt := encode(t, src, &Options{RestartInterval: ri, OptimizeHuffman: true})
+		if len(opt) >= len(std) {
+			t.Errorf("interval %d: optimized size=%d standard size=%d", ri, len(opt), len(std))
+		}
+
+		// the coefficients are the same
+		exp, err := decoder.Decode(bytes.NewReader(std))
+		if err != nil {
+			t.Fatalf("interval %d: Decode: %v", ri, err)
+		}
+		act, err := decoder.Decode(bytes.NewReader(opt))
+		if err != nil {
+			t.Fatalf("interval %d: Decode: %v", ri, err)
+		}
+		if e := meanError(t, exp, act); e != 0 {
+			t.Errorf("interval %d: mean error=%v", ri, e)
+		}
+	}
+}
+
+func TestEncode_progressive(t *testing.T) {
+	src := testImage(45, 27)
+	gray := image.NewGray(src.Bounds())
+	for i := range gray.Pix {
+		gray.Pix[i] = uint8(i%gray.Stride*5 + i/gray.Stride*3)
+	}
+
+	for _, c := range []struct {
+		name string
+		img  image.Image
+		opts Options
+	}{
+		{"default", src, Options{}},
+		{"restart", src, Options{RestartInterval: 3}},
+		{"422", src, Options{Subsampling: Subsampling422, Quality: 95}},
+		{"gray", gray, Options{RestartInterval: 4}},
+		{"script", src, Options{Subsampling: Subsampling444, Scans: []Scan{
+			{Components: []int{0}, Ss: 0, Se: 0, Ah: 0, Al: 2},
+			{Components: []int{1, 2}, Ss: 0, Se: 0, Ah: 0, Al: 0},
+			{Components: []int{0}, Ss: 1, Se: 63, Ah: 0, Al: 3},
+			{Components: []int{0}, Ss: 0, Se: 0, Ah: 2, Al: 1},
+			{Components: []int{0}, Ss: 1, Se: 63, Ah: 3, Al: 2},
+			{Components: []int{0}, Ss: 1, Se: 63, Ah: 2, Al: 1},
+			{Components: []int{0}, Ss: 1, Se: 63, Ah: 1, Al: 0},
+			{Components: []int{0}, Ss: 0, Se: 0, Ah: 1, Al: 0},
+			{Components: []int{1}, Ss: 1, Se: 63, Ah: 0, Al: 0},
+			{Components: []int{2}, Ss: 1, Se: 9, Ah: 0, Al: 0},
+			{Components: []int{2}, Ss: 10, Se: 63, Ah: 0, Al: 0},
+		}}},
+	} {
+		seq := encode(t, c.img, &c.opts)
+
+		opts := c.opts
+		opts.Progressive = true
+		prog := encode(t, c.img, &opts)
+		if !bytes.Contains(prog, []byte{0xFF, 0xC2}) {
+			t.Errorf("%s: no SOF2", c.name)
+		}
+
+		// the coefficients are the same as the sequential image
+		exp, err := decoder.Decode(bytes.NewReader(seq))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		act, err := decoder.Decode(bytes.NewReader(prog))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		if e := meanError(t, exp, act); e != 0 {
+			t.Errorf("%s: mean error=%v", c.name, e)
+		}
+
+		if c.opts.RestartInterval != 0 && !isGray(c.img) {
+			// image/jpeg counts the restart interval of a non-interleaved scan
+			// in MCUs of the frame instead of data units
+			continue
+		}
+		std, err := jpeg.Decode(bytes.NewReader(prog))
+		if err != nil {
+			t.Fatalf("%s: jpeg.Decode: %v", c.name, err)
+		}
+		if e := meanError(t, c.img, std); e > 4 {
+			t.Errorf("%s: image/jpeg: mean error=%v", c.name, e)
+		}
+	}
+}
+
+func TestValidateScans(t *testing.T) {
+	if err := validateScans(SimpleProgression(3), 3); err != nil {
+		t.Errorf("SimpleProgression(3): %v", err)
+	}
+	if err := validateScans(SimpleProgression(1), 1); err != nil {
+		t.Errorf("SimpleProgression(1): %v", err)
+	}
+
+	for i, scans := range [][]Scan{
+		nil,
+		// no DC of component 1
+		{{Components: []int{0}}},
+		// components not in increasing order
+		{{Components: []int{1, 0}}},
+		// component out of range
+		{{Components: []int{0, 2}}},
+		// DC and AC in a scan
+		{{Components: []int{0, 1}, Se: 1}},
+		// AC scan of multiple components
+		{{Components: []int{0, 1}}, {Components: []int{0, 1}, Ss: 1, Se: 63}},
+		// AC before DC
+		{{Components: []int{0}, Ss: 1, Se: 63}, {Components: []int{0, 1}}},
+		// Se > 63
+		{{Components: []int{0, 1}}, {Components: []int{0}, Ss: 1, Se: 64}},
+		// refinement of uncoded coefficients
+		{{Components: []int{0, 1}, Ah: 1}},
+		// Al is not Ah-1
+		{{Components: []int{0, 1}, Al: 3}, {Components: []int{0, 1}, Ah: 3, Al: 1}},
+		// coded twice
+		{{Components: []int{0, 1}}, {Components: []int{0}}},
+	} {
+		if err := validateScans(scans, 2); err != ErrInvalidScanScript {
+			t.Errorf("script %d: err=%v", i, err)
+		}
+	}
+
+	err := Encode(&bytes.Buffer{}, testImage(8, 8), &Options{
+		Progressive: true,
+		Scans:       []Scan{{Components: []int{0, 1}}},
+	})
+	if err != ErrInvalidScanScript {
+		t.Errorf("Encode: err=%v", err)
+	}
+}
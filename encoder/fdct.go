@@ -0,0 +1,316 @@
+package encoder
+
+import (
+	"errors"
+	"math"
+
+	"github.com/yunomu/jpeg/jpeg"
+)
+
+// DCTMethod is the implementation of the forward DCT.
+type DCTMethod = jpeg.DCTMethod
+
+const (
+	DCTISlow  = jpeg.DCTISlow
+	DCTIFast  = jpeg.DCTIFast
+	DCTFloat  = jpeg.DCTFloat
+	DCTMatrix = jpeg.DCTMatrix
+)
+
+var ErrInvalidDCTMethod = errors.New("invalid DCT method")
+
+// fdctFunc transforms the level shifted samples of the precision p in natural order
+// into the DCT coefficients in natural order.
+type fdctFunc func(in *[64]int32, out *[64]float64, p uint8)
+
+// quantizeFunc quantizes the DCT coefficient f of the zigzag index k by q.
+type quantizeFunc func(f float64, k int, q uint16) int32
+
+// fdctMethod returns the DCT of the method and the quantization of its outputs,
+// which is the same as libjpeg except DCTMatrix.
+func fdctMethod(m DCTMethod) (fdctFunc, quantizeFunc, error) {
+	switch m {
+	case DCTISlow:
+		return fdctIslow, quantizeRound, nil
+	case DCTIFast:
+		return fdctIFast, quantizeIFast, nil
+	case DCTFloat:
+		return fdctFloat, quantizeFloat, nil
+	case DCTMatrix:
+		return fdctMatrix, quantizeRound, nil
+	}
+	return nil, nil, ErrInvalidDCTMethod
+}
+
+// quantizeRound rounds half away from zero the coefficient divided by q.
+func quantizeRound(f float64, k int, q uint16) int32 {
+	return int32(math.Round(f / float64(q)))
+}
+
+// quantizeIFast quantizes the output of fdctIFast by the divisor of q scaled by
+// the AAN scale factors and rounded to an integer as libjpeg.
+func quantizeIFast(f float64, k int, q uint16) int32 {
+	x := int32(math.Round(f * aanScale[k]))
+	d := (int32(q)*aanScaleFix[k] + 1<<10) >> 11
+	if x < 0 {
+		return -((-x + d>>1) / d)
+	}
+	return (x + d>>1) / d
+}
+
+// quantizeFloat quantizes the output of fdctFloat by the reciprocal of q scaled by
+// the AAN scale factors in float32 as libjpeg.
+func quantizeFloat(f float64, k int, q uint16) int32 {
+	x := float32(f * aanScale[k])
+	r, c := natural[k]/8, natural[k]%8
+	d := float32(1 / (float64(q) * aanScaleFactor[r] * aanScaleFactor[c] * 8))
+	// the product is rounded to float32 before the addition as libjpeg
+	return int32(float32(x*d)+16384.5) - 16384
+}
+
+// Constants of the integer DCT of libjpeg (jfdctint.c), scaled by 2^13.
+const (
+	fdctConstBits = 13
+
+	fix0_298631336 = 2446
+	fix0_390180644 = 3196
+	fix0_541196100 = 4433
+	fix0_765366865 = 6270
+	fix0_899976223 = 7373
+	fix1_175875602 = 9633
+	fix1_501321110 = 12299
+	fix1_847759065 = 15137
+	fix1_961570560 = 16069
+	fix2_053119869 = 16819
+	fix2_562915447 = 20995
+	fix3_072711026 = 25172
+)
+
+func descale(x int32, n uint) int32 {
+	return (x + 1<<(n-1)) >> n
+}
+
+// fdctIslow is the separable integer DCT of libjpeg (jpeg_fdct_islow).
+func fdctIslow(in *[64]int32, out *[64]float64, p uint8) {
+	// the intermediate values are scaled by 2^pass1 (libjpeg PASS1_BITS)
+	pass1 := uint(2)
+	if p > 8 {
+		pass1 = 1
+	}
+
+	ws := *in
+	for r := 0; r < 64; r += 8 {
+		islowPass(&ws, r, 1, pass1, true)
+	}
+	for c := 0; c < 8; c++ {
+		islowPass(&ws, c, 8, pass1, false)
+	}
+
+	// the outputs are scaled by 8
+	for i, v := range ws {
+		out[i] = float64(v) / 8
+	}
+}
+
+// islowPass transforms the 8 values of v from i by the stride with the 1-D DCT
+// of the rows, or of the columns.
+func islowPass(v *[64]int32, i, stride int, pass1 uint, rows bool) {
+	tmp0, tmp7 := v[i]+v[i+7*stride], v[i]-v[i+7*stride]
+	tmp1, tmp6 := v[i+stride]+v[i+6*stride], v[i+stride]-v[i+6*stride]
+	tmp2, tmp5 := v[i+2*stride]+v[i+5*stride], v[i+2*stride]-v[i+5*stride]
+	tmp3, tmp4 := v[i+3*stride]+v[i+4*stride], v[i+3*stride]-v[i+4*stride]
+
+	// even part
+	tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+	tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+
+	n := uint(fdctConstBits + pass1)
+	if rows {
+		v[i] = (tmp10 + tmp11) << pass1
+		v[i+4*stride] = (tmp10 - tmp11) << pass1
+		n = fdctConstBits - pass1
+	} else {
+		v[i] = descale(tmp10+tmp11, pass1)
+		v[i+4*stride] = descale(tmp10-tmp11, pass1)
+	}
+
+	z1 := (tmp12 + tmp13) * fix0_541196100
+	v[i+2*stride] = descale(z1+tmp13*fix0_765366865, n)
+	v[i+6*stride] = descale(z1-tmp12*fix1_847759065, n)
+
+	// odd part
+	z1, z2 := tmp4+tmp7, tmp5+tmp6
+	z3, z4 := tmp4+tmp6, tmp5+tmp7
+	z5 := (z3 + z4) * fix1_175875602
+
+	tmp4 *= fix0_298631336
+	tmp5 *= fix2_053119869
+	tmp6 *= fix3_072711026
+	tmp7 *= fix1_501321110
+	z1 *= -fix0_899976223
+	z2 *= -fix2_562915447
+	z3 = z3*-fix1_961570560 + z5
+	z4 = z4*-fix0_390180644 + z5
+
+	v[i+7*stride] = descale(tmp4+z1+z3, n)
+	v[i+5*stride] = descale(tmp5+z2+z4, n)
+	v[i+3*stride] = descale(tmp6+z2+z3, n)
+	v[i+stride] = descale(tmp7+z1+z4, n)
+}
+
+// Constants of the fast integer DCT of libjpeg (jfdctfst.c), scaled by 2^8.
+const (
+	ifastConstBits = 8
+
+	ifix0_382683433 = 98
+	ifix0_541196100 = 139
+	ifix0_707106781 = 181
+	ifix1_306562965 = 334
+)
+
+func ifastMul(x, c int32) int32 {
+	return (x * c) >> ifastConstBits
+}
+
+// aanScale is the scales of the outputs of the AAN DCT in zigzag order, and
+// aanScaleFix is the products of the scale factors of the rows and columns
+// scaled by 2^14 in zigzag order, aanscales of libjpeg.
+var (
+	aanScale    [64]float64
+	aanScaleFix [64]int32
+)
+
+// natural is the indexes in natural order of the coefficients in zigzag order.
+var natural [64]int
+
+// aanScaleFactor is cos(k*pi/16)*sqrt(2) except 1 for k = 0, aanscalefactor of libjpeg.
+var aanScaleFactor = [8]float64{1.0, 1.387039845, 1.306562965, 1.175875602, 1.0, 0.785694958, 0.541196100, 0.275899379}
+
+func init() {
+	s := aanScaleFactor
+	for i, k := range jpeg.Unzig {
+		natural[k] = i
+		aanScale[k] = s[i/8] * s[i%8] * 8
+		aanScaleFix[k] = int32(math.Round(s[i/8] * s[i%8] * (1 << 14)))
+	}
+}
+
+// fdctIFast is the AAN integer DCT of libjpeg (jpeg_fdct_ifast).
+func fdctIFast(in *[64]int32, out *[64]float64, p uint8) {
+	ws := *in
+	for r := 0; r < 64; r += 8 {
+		aanInt(&ws, r, 1)
+	}
+	for c := 0; c < 8; c++ {
+		aanInt(&ws, c, 8)
+	}
+
+	for i, k := range jpeg.Unzig {
+		out[i] = float64(ws[i]) / aanScale[k]
+	}
+}
+
+// aanInt transforms the 8 values of v from i by the stride with the 1-D DCT
+// of the AAN algorithm in integers.
+func aanInt(v *[64]int32, i, stride int) {
+	tmp0, tmp7 := v[i]+v[i+7*stride], v[i]-v[i+7*stride]
+	tmp1, tmp6 := v[i+stride]+v[i+6*stride], v[i+stride]-v[i+6*stride]
+	tmp2, tmp5 := v[i+2*stride]+v[i+5*stride], v[i+2*stride]-v[i+5*stride]
+	tmp3, tmp4 := v[i+3*stride]+v[i+4*stride], v[i+3*stride]-v[i+4*stride]
+
+	// even part
+	tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+	tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+
+	v[i] = tmp10 + tmp11
+	v[i+4*stride] = tmp10 - tmp11
+
+	z1 := ifastMul(tmp12+tmp13, ifix0_707106781)
+	v[i+2*stride] = tmp13 + z1
+	v[i+6*stride] = tmp13 - z1
+
+	// odd part
+	tmp10, tmp11, tmp12 = tmp4+tmp5, tmp5+tmp6, tmp6+tmp7
+
+	z5 := ifastMul(tmp10-tmp12, ifix0_382683433)
+	z2 := ifastMul(tmp10, ifix0_541196100) + z5
+	z4 := ifastMul(tmp12, ifix1_306562965) + z5
+	z3 := ifastMul(tmp11, ifix0_707106781)
+
+	z11, z13 := tmp7+z3, tmp7-z3
+
+	v[i+5*stride] = z13 + z2
+	v[i+3*stride] = z13 - z2
+	v[i+stride] = z11 + z4
+	v[i+7*stride] = z11 - z4
+}
+
+// fdctFloat is the AAN floating point DCT of libjpeg (jpeg_fdct_float).
+func fdctFloat(in *[64]int32, out *[64]float64, p uint8) {
+	var ws [64]float32
+	for i, v := range in {
+		ws[i] = float32(v)
+	}
+	for r := 0; r < 64; r += 8 {
+		aan(&ws, r, 1)
+	}
+	for c := 0; c < 8; c++ {
+		aan(&ws, c, 8)
+	}
+
+	for i, k := range jpeg.Unzig {
+		out[i] = float64(ws[i]) / aanScale[k]
+	}
+}
+
+// aan transforms the 8 values of v from i by the stride with the 1-D DCT
+// of the AAN algorithm. The products are converted to float32 explicitly to be
+// rounded, since the compiler may fuse them with the additions on some
+// architectures, which changes the results.
+func aan(v *[64]float32, i, stride int) {
+	tmp0, tmp7 := v[i]+v[i+7*stride], v[i]-v[i+7*stride]
+	tmp1, tmp6 := v[i+stride]+v[i+6*stride], v[i+stride]-v[i+6*stride]
+	tmp2, tmp5 := v[i+2*stride]+v[i+5*stride], v[i+2*stride]-v[i+5*stride]
+	tmp3, tmp4 := v[i+3*stride]+v[i+4*stride], v[i+3*stride]-v[i+4*stride]
+
+	// even part
+	tmp10, tmp13 := tmp0+tmp3, tmp0-tmp3
+	tmp11, tmp12 := tmp1+tmp2, tmp1-tmp2
+
+	v[i] = tmp10 + tmp11
+	v[i+4*stride] = tmp10 - tmp11
+
+	z1 := float32((tmp12 + tmp13) * 0.707106781)
+	v[i+2*stride] = tmp13 + z1
+	v[i+6*stride] = tmp13 - z1
+
+	// odd part
+	tmp10, tmp11, tmp12 = tmp4+tmp5, tmp5+tmp6, tmp6+tmp7
+
+	z5 := float32((tmp10 - tmp12) * 0.382683433)
+	z2 := float32(tmp10*0.541196100) + z5
+	z4 := float32(tmp12*1.306562965) + z5
+	z3 := float32(tmp11 * 0.707106781)
+
+	z11, z13 := tmp7+z3, tmp7-z3
+
+	v[i+5*stride] = z13 + z2
+	v[i+3*stride] = z13 - z2
+	v[i+stride] = z11 + z4
+	v[i+7*stride] = z11 - z4
+}
+
+// fdctMatrix is the DCT by the matrix multiplication of float64.
+func fdctMatrix(in *[64]int32, out *[64]float64, p uint8) {
+	s := make([][]int16, 8)
+	for y := range s {
+		s[y] = make([]int16, 8)
+		for x := range s[y] {
+			s[y][x] = int16(in[y*8+x])
+		}
+	}
+
+	for y, row := range jpeg.Dct(s) {
+		copy(out[y*8:], row)
+	}
+}
@@ -0,0 +1,82 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestFDCT(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, c := range []struct {
+		m      DCTMethod
+		maxErr float64
+	}{
+		{DCTISlow, 0.2},
+		{DCTIFast, 8},
+		{DCTFloat, 0.01},
+	} {
+		fdct, _, err := fdctMethod(c.m)
+		if err != nil {
+			t.Fatalf("%v: %v", c.m, err)
+		}
+
+		for _, p := range []uint8{8, 12} {
+			for n := 0; n < 100; n++ {
+				var in [64]int32
+				for i := range in {
+					in[i] = int32(r.Intn(1<<p) - 1<<(p-1))
+				}
+
+				var exp, act [64]float64
+				fdctMatrix(&in, &exp, p)
+				fdct(&in, &act, p)
+				for i := range exp {
+					if d := math.Abs(exp[i] - act[i]); d > c.maxErr*float64(int(1)<<(p-8)) {
+						t.Fatalf("%v p=%d [%d]: exp=%v act=%v", c.m, p, i, exp[i], act[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+// scanData returns the data from the first SOS marker.
+func scanData(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	i := bytes.Index(data, []byte{0xFF, 0xDA})
+	if i < 0 {
+		t.Fatalf("no SOS")
+	}
+	return data[i:]
+}
+
+func TestEncode_dctMethod(t *testing.T) {
+	src := testTexture(64, 48)
+	gray := image.NewGray(src.Bounds())
+	for i := range gray.Pix {
+		gray.Pix[i] = src.Pix[i*4+1]
+	}
+
+	// the images of the same samples encoded by the C code of libjpeg with dct_method
+	for _, m := range []DCTMethod{DCTISlow, DCTIFast, DCTFloat} {
+		exp, err := os.ReadFile("testdata/texture_" + m.String() + ".jpg")
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+
+		act := encode(t, gray, &Options{Quality: 90, DCTMethod: m})
+		if !bytes.Equal(scanData(t, exp), scanData(t, act)) {
+			t.Errorf("%v: mismatch", m)
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, gray, &Options{DCTMethod: 4}); err != ErrInvalidDCTMethod {
+		t.Errorf("err=%v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package encoder
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+type component struct {
+	c    uint8
+	h, v uint8
+	qt   *quantizationTable
+	dc   *hufftable
+	ac   *hufftable
+
+	x, y   int           // number of samples per line and column
+	bw, bh int           // number of blocks per line and column, padded to the MCU
+	pix    []uint16      // bw*8 x bh*8 samples
+	lpix   []uint16      // x*y point transformed samples of lossless images
+	dcts   [][64]float64 // DCT coefficients in zigzag order, bw*bh blocks
+	coefs  [][64]int32   // quantized coefficients in zigzag order, bw*bh blocks
+	pred   int32
+
+	dcContext int // conditioning of the DC difference of arithmetic coding
+}
+
+func (c *component) String() string {
+	if c.qt == nil {
+		return fmt.Sprintf("(C=%d H=%d V=%d x=%d y=%d)", c.c, c.h, c.v, c.x, c.y)
+	}
+	return fmt.Sprintf("(C=%d H=%d V=%d Tq=%d bw=%d bh=%d)", c.c, c.h, c.v, c.qt.tq, c.bw, c.bh)
+}
+
+func (c *component) coef(bx, by int) *[64]int32 {
+	return &c.coefs[by*c.bw+bx]
+}
+
+// block sets the samples of the block level shifted for the precision p to b.
+func (c *component) block(bx, by int, p uint8, b *[64]int32) {
+	shift := int32(1) << (p - 1)
+	stride := c.bw * 8
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			b[y*8+x] = int32(c.pix[(by*8+y)*stride+bx*8+x]) - shift
+		}
+	}
+}
+
+type frame struct {
+	p          uint8
+	x, y       uint16
+	mcuX, mcuY int
+	comps      []*component
+
+	quant quantizeFunc // quantization of the DCT coefficients
+}
+
+func (h *frame) String() string {
+	return fmt.Sprintf("((x, y)=(%d, %d) Nf=%d comps=%v)", h.x, h.y, len(h.comps), h.comps)
+}
+
+func (h *frame) quantizationTables() []*quantizationTable {
+	var ret []*quantizationTable
+	for _, c := range h.comps {
+		if len(ret) == 0 || ret[len(ret)-1] != c.qt {
+			ret = append(ret, c.qt)
+		}
+	}
+	return ret
+}
+
+func (h *frame) hufftables() []*hufftable {
+	var ret []*hufftable
+	for _, c := range h.comps {
+		if len(ret) == 0 || ret[len(ret)-1] != c.ac {
+			ret = append(ret, c.dc, c.ac)
+		}
+	}
+	return ret
+}
+
+// rgbToYCbCr converts the RGB color to the YCbCr color of the precision p
+// as in libjpeg.
+func rgbToYCbCr(r, g, b uint16, p uint8) (uint16, uint16, uint16) {
+	c := int64(1) << (p - 1)
+	maxv := int64(1)<<p - 1
+
+	r1, g1, b1 := int64(r), int64(g), int64(b)
+	y := (19595*r1 + 38470*g1 + 7471*b1 + 1<<15) >> 16
+	cb := (-11059*r1-21709*g1+32768*b1+1<<15)>>16 + c
+	cr := (32768*r1-27439*g1-5329*b1+1<<15)>>16 + c
+
+	return uint16(clamp(y, maxv)), uint16(clamp(cb, maxv)), uint16(clamp(cr, maxv))
+}
+
+func clamp(v, maxv int64) int64 {
+	return min(max(v, 0), maxv)
+}
+
+// pixelFunc returns the function which converts the pixel of the image to the
+// YCbCr color of the precision p.
+func pixelFunc(m image.Image, p uint8) func(x, y int) (uint16, uint16, uint16) {
+	if p != 8 {
+		sh := 16 - p
+		c := uint16(1) << (p - 1)
+		if g, ok := m.(*image.Gray16); ok {
+			return func(x, y int) (uint16, uint16, uint16) {
+				return g.Gray16At(x, y).Y >> sh, c, c
+			}
+		}
+		return func(x, y int) (uint16, uint16, uint16) {
+			r, g, b, _ := m.At(x, y).RGBA()
+			return rgbToYCbCr(uint16(r)>>sh, uint16(g)>>sh, uint16(b)>>sh, p)
+		}
+	}
+
+	switch m := m.(type) {
+	case *image.YCbCr:
+		return func(x, y int) (uint16, uint16, uint16) {
+			c := m.YCbCrAt(x, y)
+			return uint16(c.Y), uint16(c.Cb), uint16(c.Cr)
+		}
+	case *image.Gray:
+		return func(x, y int) (uint16, uint16, uint16) {
+			return uint16(m.GrayAt(x, y).Y), 128, 128
+		}
+	}
+
+	return func(x, y int) (uint16, uint16, uint16) {
+		r, g, b, _ := m.At(x, y).RGBA()
+		yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		return uint16(yy), uint16(cb), uint16(cr)
+	}
+}
+
+// readPlanes returns the planes of w*h samples of the components of the image.
+// The last line and column of the image are replicated beyond its bounds.
+func readPlanes(m image.Image, n, w, h int, p uint8) [][]uint16 {
+	planes := make([][]uint16, n)
+	for i := range planes {
+		planes[i] = make([]uint16, w*h)
+	}
+
+	b := m.Bounds()
+	at := pixelFunc(m, p)
+	for y := 0; y < h; y++ {
+		sy := b.Min.Y + min(y, b.Dy()-1)
+		for x := 0; x < w; x++ {
+			sx := b.Min.X + min(x, b.Dx()-1)
+			yy, cb, cr := at(sx, sy)
+			planes[0][y*w+x] = yy
+			if n == 3 {
+				planes[1][y*w+x] = cb
+				planes[2][y*w+x] = cr
+			}
+		}
+	}
+
+	return planes
+}
+
+// downsample averages the boxes of sh*sv samples of the plane of w*h samples.
+func downsample(p []uint16, w, h, sh, sv int) []uint16 {
+	if sh == 1 && sv == 1 {
+		return p
+	}
+
+	dw, dh := w/sh, h/sv
+	n := sh * sv
+	ret := make([]uint16, dw*dh)
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sum := 0
+			for j := 0; j < sv; j++ {
+				for i := 0; i < sh; i++ {
+					sum += int(p[(y*sv+j)*w+x*sh+i])
+				}
+			}
+			ret[y*dw+x] = uint16((sum + n/2) / n)
+		}
+	}
+
+	return ret
+}
+
+// newFrame converts the image into the components of a frame of the precision p
+// transformed by the DCT of the method.
+func newFrame(m image.Image, gray bool, s Subsampling, p uint8, dm DCTMethod, luma, chroma *quantizationTable) (*frame, error) {
+	b := m.Bounds()
+
+	hmax, vmax, err := s.factors()
+	if err != nil {
+		return nil, err
+	}
+
+	fdct, quant, err := fdctMethod(dm)
+	if err != nil {
+		return nil, err
+	}
+
+	comps := []*component{
+		{c: 1, h: hmax, v: vmax},
+	}
+	if gray {
+		hmax, vmax = 1, 1
+		comps[0].h, comps[0].v = 1, 1
+	} else {
+		comps = append(comps,
+			&component{c: 2, h: 1, v: 1},
+			&component{c: 3, h: 1, v: 1},
+		)
+	}
+
+	mcux := (b.Dx() + 8*int(hmax) - 1) / (8 * int(hmax))
+	mcuy := (b.Dy() + 8*int(vmax) - 1) / (8 * int(vmax))
+	w, h := mcux*8*int(hmax), mcuy*8*int(vmax)
+
+	planes := readPlanes(m, len(comps), w, h, p)
+	for i, c := range comps {
+		c.x = (b.Dx()*int(c.h) + int(hmax) - 1) / int(hmax)
+		c.y = (b.Dy()*int(c.v) + int(vmax) - 1) / int(vmax)
+		c.bw = mcux * int(c.h)
+		c.bh = mcuy * int(c.v)
+		c.pix = downsample(planes[i], w, h, int(hmax/c.h), int(vmax/c.v))
+		c.transform(p, fdct)
+	}
+
+	ret := &frame{
+		p:     p,
+		x:     uint16(b.Dx()),
+		y:     uint16(b.Dy()),
+		mcuX:  mcux,
+		mcuY:  mcuy,
+		comps: comps,
+		quant: quant,
+	}
+	ret.setHufftables()
+	ret.quantize(luma, chroma)
+
+	return ret, nil
+}
+
+// setHufftables sets the tables of Annex K.3 to the components.
+func (h *frame) setHufftables() {
+	h.comps[0].dc, h.comps[0].ac = newHufftable(0, 0, &stdDCLuma), newHufftable(1, 0, &stdACLuma)
+	if len(h.comps) == 1 {
+		return
+	}
+
+	dc, ac := newHufftable(0, 1, &stdDCChroma), newHufftable(1, 1, &stdACChroma)
+	for _, c := range h.comps[1:] {
+		c.dc, c.ac = dc, ac
+	}
+}
+
+// quantize quantizes the DCT coefficients of the components with the luminance
+// and chrominance tables.
+func (h *frame) quantize(luma, chroma *quantizationTable) {
+	for i, c := range h.comps {
+		c.qt = chroma
+		if i == 0 {
+			c.qt = luma
+		}
+		c.quantize(h.quant)
+	}
+}
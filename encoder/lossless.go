@@ -0,0 +1,160 @@
+package encoder
+
+import (
+	"errors"
+	"image"
+	"image/color"
+
+	"github.com/yunomu/jpeg/decoder"
+	"github.com/yunomu/jpeg/lib/delta"
+)
+
+var (
+	ErrInvalidPrecision      = errors.New("invalid precision")
+	ErrInvalidPredictor      = errors.New("invalid predictor")
+	ErrInvalidPointTransform = errors.New("invalid point transform")
+)
+
+func is16(m image.Image) bool {
+	switch m.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model, color.NRGBA64Model:
+		return true
+	}
+	return false
+}
+
+// newLosslessFrame converts the image into the components of a lossless frame.
+// The samples are reduced to the precision and the point transform of the options.
+func newLosslessFrame(m image.Image, gray bool, opts *Options) (*frame, error) {
+	p := opts.Precision
+	if p == 0 {
+		p = 8
+		if is16(m) {
+			p = 16
+		}
+	}
+	if p < 2 || p > 16 {
+		return nil, ErrInvalidPrecision
+	}
+	if opts.PointTransform >= p {
+		return nil, ErrInvalidPointTransform
+	}
+	if !opts.Predictor.Valid() {
+		return nil, ErrInvalidPredictor
+	}
+
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// a restart interval is an integer multiple of the MCUs of a line (H.1.1)
+	if opts.RestartInterval%w != 0 {
+		return nil, ErrInvalidRestartInterval
+	}
+
+	ids := []uint8{'R', 'G', 'B'}
+	if gray {
+		ids = []uint8{1}
+	}
+
+	var comps []*component
+	for i, id := range ids {
+		comps = append(comps, &component{
+			c:    id,
+			h:    1,
+			v:    1,
+			dc:   &hufftable{class: 0, target: uint8(i)},
+			x:    w,
+			y:    h,
+			lpix: make([]uint16, w*h),
+		})
+	}
+
+	sh := 16 - p + opts.PointTransform
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := y*w + x
+			c := m.At(b.Min.X+x, b.Min.Y+y)
+			if gray {
+				comps[0].lpix[i] = color.Gray16Model.Convert(c).(color.Gray16).Y >> sh
+				continue
+			}
+
+			r, g, b, _ := c.RGBA()
+			comps[0].lpix[i] = uint16(r) >> sh
+			comps[1].lpix[i] = uint16(g) >> sh
+			comps[2].lpix[i] = uint16(b) >> sh
+		}
+	}
+
+	return &frame{
+		p:     p,
+		x:     uint16(w),
+		y:     uint16(h),
+		mcuX:  w,
+		mcuY:  h,
+		comps: comps,
+	}, nil
+}
+
+// writeDiff writes the difference of the lossless process (H.1.2.2).
+func (e *Encoder) writeDiff(t *hufftable, diff int32) {
+	if diff == 32768 {
+		// the category 16 has no additional bits
+		e.writeHuffman(t, 16)
+		return
+	}
+
+	e.writeValue(t, 0, diff)
+}
+
+// encodeLosslessScan encodes the samples of the interleaved scan of all the
+// components. The prediction restarts at the beginning of each restart interval.
+func (e *Encoder) encodeLosslessScan(h *frame, pred delta.Predictor, pt uint8, interval int) {
+	prec := h.p - pt
+
+	n := 0
+	var x0, y0 int
+	for y := 0; y < int(h.y); y++ {
+		for x := 0; x < int(h.x); x++ {
+			if interval > 0 && n > 0 && n%interval == 0 {
+				e.writeRST(n/interval-1, h.comps)
+				x0, y0 = x, y
+			}
+			n++
+
+			for _, c := range h.comps {
+				p := pred.Predict(c.lpix, c.x, x, y, x0, y0, prec)
+				e.writeDiff(c.dc, delta.Diff(c.lpix[y*c.x+x], p))
+			}
+		}
+	}
+	e.flushBits()
+}
+
+// encodeLossless encodes the frame with the Huffman tables optimized for the differences.
+func (e *Encoder) encodeLossless(h *frame) error {
+	pred := e.opts.Predictor
+	if pred == 0 {
+		pred = 1
+	}
+	pt := e.opts.PointTransform
+
+	s := &scan{comps: h.comps, ss: uint8(pred), al: pt}
+	hts := s.hufftables(false)
+	err := e.optimizeHufftables(hts, func() {
+		e.encodeLosslessScan(h, pred, pt, e.opts.RestartInterval)
+	})
+	if err != nil {
+		return err
+	}
+
+	e.writeSOF(decoder.Marker_SOF3, h)
+	e.writeDHT(hts)
+	if e.opts.RestartInterval > 0 {
+		e.writeDRI(e.opts.RestartInterval)
+	}
+	e.writeSOS(s.comps, s.ss, s.se, s.ah, s.al)
+	e.encodeLosslessScan(h, pred, pt, e.opts.RestartInterval)
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/yunomu/jpeg/decoder"
+	"github.com/yunomu/jpeg/lib/delta"
+)
+
+func testGray16(w, h int) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := x*397 + y*1009 + (x*y)%97*131
+			if x == 5 && y == 3 {
+				v += 32768
+			}
+			img.SetGray16(x, y, color.Gray16{Y: uint16(v)})
+		}
+	}
+	return img
+}
+
+func TestEncode_lossless16(t *testing.T) {
+	src := testGray16(31, 19)
+
+	for pred := delta.Predictor(1); pred <= 7; pred++ {
+		for _, ri := range []int{0, 62} {
+			data := encode(t, src, &Options{Lossless: true, Predictor: pred, RestartInterval: ri})
+
+			img, err := decoder.Decode(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("predictor %d: Decode: %v", pred, err)
+			}
+
+			gray, ok := img.(*image.Gray16)
+			if !ok {
+				t.Fatalf("predictor %d: unexpected image type: %T", pred, img)
+			}
+			if !bytes.Equal(gray.Pix, src.Pix) {
+				t.Errorf("predictor %d interval %d: samples mismatch", pred, ri)
+			}
+		}
+	}
+}
+
+func TestEncode_losslessPrecision(t *testing.T) {
+	src := testGray16(17, 9)
+
+	data := encode(t, src, &Options{Lossless: true, Predictor: 7, Precision: 12, PointTransform: 2})
+	if !bytes.Contains(data, []byte{0xFF, 0xC3, 0, 11, 12, 0, 9, 0, 17, 1}) {
+		t.Errorf("no SOF3 of 12-bit")
+	}
+
+	img, err := decoder.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	gray := img.(*image.Gray16)
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 17; x++ {
+			exp := src.Gray16At(x, y).Y >> 6
+			if act := gray.Gray16At(x, y).Y >> 6; act != exp {
+				t.Fatalf("(%d, %d): exp=%d act=%d", x, y, exp, act)
+			}
+		}
+	}
+}
+
+func TestEncode_losslessRGB(t *testing.T) {
+	src := testImage(23, 14)
+
+	data := encode(t, src, &Options{Lossless: true, Predictor: 4, RestartInterval: 23})
+	if bytes.Contains(data, []byte("JFIF")) {
+		t.Errorf("JFIF segment in RGB image")
+	}
+
+	img, err := decoder.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	if e := meanError(t, src, img); e != 0 {
+		t.Errorf("mean error=%v", e)
+	}
+}
+
+func TestEncode_losslessInvalid(t *testing.T) {
+	src := testGray16(8, 8)
+
+	for _, c := range []struct {
+		opts Options
+		err  error
+	}{
+		{Options{Lossless: true, Precision: 1}, ErrInvalidPrecision},
+		{Options{Lossless: true, Precision: 17}, ErrInvalidPrecision},
+		{Options{Lossless: true, Precision: 8, PointTransform: 8}, ErrInvalidPointTransform},
+		{Options{Lossless: true, Predictor: 8}, ErrInvalidPredictor},
+		{Options{Lossless: true, RestartInterval: 12}, ErrInvalidRestartInterval},
+	} {
+		if err := Encode(&bytes.Buffer{}, src, &c.opts); err != c.err {
+			t.Errorf("%+v: err=%v exp=%v", c.opts, err, c.err)
+		}
+	}
+}
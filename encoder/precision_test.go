@@ -0,0 +1,126 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/yunomu/jpeg/decoder"
+)
+
+func testRGBA64(w, h int) *image.RGBA64 {
+	img := image.NewRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(x * 65535 / w),
+				G: uint16(y * 65535 / h),
+				B: uint16(20000 + x*900 + y*500),
+				A: 0xFFFF,
+			})
+		}
+	}
+	return img
+}
+
+// meanError16 returns the mean absolute difference of the 16-bit RGB values of the images.
+func meanError16(t *testing.T, a, b image.Image) float64 {
+	t.Helper()
+
+	if a.Bounds() != b.Bounds() {
+		t.Fatalf("bounds: %v != %v", a.Bounds(), b.Bounds())
+	}
+
+	abs := func(a, b uint32) int {
+		d := int(a) - int(b)
+		if d < 0 {
+			return -d
+		}
+		return d
+	}
+
+	bounds := a.Bounds()
+	sum := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r0, g0, b0, _ := a.At(x, y).RGBA()
+			r1, g1, b1, _ := b.At(x, y).RGBA()
+			sum += abs(r0, r1) + abs(g0, g1) + abs(b0, b1)
+		}
+	}
+
+	return float64(sum) / float64(3*bounds.Dx()*bounds.Dy())
+}
+
+func TestEncode_12bit(t *testing.T) {
+	gray := image.NewGray16(image.Rect(0, 0, 37, 21))
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			gray.SetGray16(x, y, color.Gray16{Y: uint16(x*1500 + y*300)})
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		img  image.Image
+		opts Options
+	}{
+		{"gray", gray, Options{Quality: 95}},
+		{"rgb", testRGBA64(37, 21), Options{Quality: 95, Subsampling: Subsampling444}},
+		{"restart", testRGBA64(37, 21), Options{Quality: 90, RestartInterval: 2}},
+	} {
+		data := encode(t, c.img, &c.opts)
+		if !bytes.Contains(data, []byte{0xFF, 0xC1}) {
+			t.Errorf("%s: no SOF1", c.name)
+		}
+		if i := bytes.Index(data, []byte{0xFF, 0xDB}); i < 0 || data[i+4]>>4 != 1 {
+			t.Errorf("%s: no DQT with Pq=1", c.name)
+		}
+
+		img, err := decoder.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		switch img.(type) {
+		case *image.Gray16, *image.RGBA64:
+		default:
+			t.Fatalf("%s: unexpected image type: %T", c.name, img)
+		}
+
+		// more accurate than the image truncated to 8 bits
+		opts := c.opts
+		opts.Precision = 8
+		img8, err := decoder.Decode(bytes.NewReader(encode(t, c.img, &opts)))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		if e, e8 := meanError16(t, c.img, img), meanError16(t, c.img, img8); e >= e8 {
+			t.Errorf("%s: mean error=%v 8-bit=%v", c.name, e, e8)
+		}
+
+		// the coefficients of the other processes are the same
+		for _, opts := range []Options{
+			{Progressive: true},
+			{Arithmetic: true},
+			{Progressive: true, Arithmetic: true},
+		} {
+			opts.Quality, opts.Subsampling, opts.RestartInterval = c.opts.Quality, c.opts.Subsampling, c.opts.RestartInterval
+			act, err := decoder.Decode(bytes.NewReader(encode(t, c.img, &opts)))
+			if err != nil {
+				t.Fatalf("%s %+v: Decode: %v", c.name, opts, err)
+			}
+			if e := meanError16(t, img, act); e != 0 {
+				t.Errorf("%s %+v: mean error=%v", c.name, opts, e)
+			}
+		}
+	}
+}
+
+func TestEncode_invalidPrecision(t *testing.T) {
+	for _, p := range []uint8{7, 10, 16} {
+		if err := Encode(&bytes.Buffer{}, testImage(8, 8), &Options{Precision: p}); err != ErrInvalidPrecision {
+			t.Errorf("precision %d: err=%v", p, err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package encoder
+
+import (
+	"errors"
+	"log/slog"
+)
+
+var ErrInvalidScanScript = errors.New("invalid scan script")
+
+// Scan is a scan of a progressive image.
+type Scan struct {
+	Components []int // indexes of the components in the frame, in increasing order
+	Ss, Se     uint8 // spectral selection
+	Ah, Al     uint8 // successive approximation bit positions
+}
+
+// maxCorrBits is the number of the buffered correction bits of AC refinement
+// scans which forces out the EOB run, the same as libjpeg.
+const maxCorrBits = 1000
+
+func dcScans(n int, ah, al uint8) []Scan {
+	s := Scan{Ah: ah, Al: al}
+	for i := 0; i < n; i++ {
+		s.Components = append(s.Components, i)
+	}
+	return []Scan{s}
+}
+
+func acScans(n int, ss, se, ah, al uint8) []Scan {
+	var ret []Scan
+	for i := 0; i < n; i++ {
+		ret = append(ret, Scan{Components: []int{i}, Ss: ss, Se: se, Ah: ah, Al: al})
+	}
+	return ret
+}
+
+// SimpleProgression returns the scan script of jpeg_simple_progression of libjpeg
+// for n components.
+func SimpleProgression(n int) []Scan {
+	if n == 3 {
+		return []Scan{
+			{Components: []int{0, 1, 2}, Ss: 0, Se: 0, Ah: 0, Al: 1},
+			{Components: []int{0}, Ss: 1, Se: 5, Ah: 0, Al: 2},
+			{Components: []int{2}, Ss: 1, Se: 63, Ah: 0, Al: 1},
+			{Components: []int{1}, Ss: 1, Se: 63, Ah: 0, Al: 1},
+			{Components: []int{0}, Ss: 6, Se: 63, Ah: 0, Al: 2},
+			{Components: []int{0}, Ss: 1, Se: 63, Ah: 2, Al: 1},
+			{Components: []int{0, 1, 2}, Ss: 0, Se: 0, Ah: 1, Al: 0},
+			{Components: []int{2}, Ss: 1, Se: 63, Ah: 1, Al: 0},
+			{Components: []int{1}, Ss: 1, Se: 63, Ah: 1, Al: 0},
+			{Components: []int{0}, Ss: 1, Se: 63, Ah: 1, Al: 0},
+		}
+	}
+
+	var ret []Scan
+	ret = append(ret, dcScans(n, 0, 1)...)
+	ret = append(ret, acScans(n, 1, 5, 0, 2)...)
+	ret = append(ret, acScans(n, 6, 63, 0, 2)...)
+	ret = append(ret, acScans(n, 1, 63, 2, 1)...)
+	ret = append(ret, dcScans(n, 1, 0)...)
+	ret = append(ret, acScans(n, 1, 63, 1, 0)...)
+	return ret
+}
+
+// validateScans validates the scan script against the progression of G.1.1.1.
+// Every component needs at least its DC coefficients.
+func validateScans(scans []Scan, n int) error {
+	// the bit position coded last of each coefficient, -1 if not coded
+	last := make([][64]int, n)
+	for i := range last {
+		for k := range last[i] {
+			last[i][k] = -1
+		}
+	}
+
+	invalid := func(i int, s *Scan, msg string) error {
+		slog.Error("invalid scan script", "scan", i, "Components", s.Components, "Ss", s.Ss, "Se", s.Se, "Ah", s.Ah, "Al", s.Al, "reason", msg)
+		return ErrInvalidScanScript
+	}
+
+	if len(scans) == 0 {
+		return ErrInvalidScanScript
+	}
+
+	for i := range scans {
+		s := &scans[i]
+
+		if len(s.Components) == 0 || len(s.Components) > 4 {
+			return invalid(i, s, "number of components")
+		}
+		for j, c := range s.Components {
+			if c < 0 || c >= n || (j > 0 && c <= s.Components[j-1]) {
+				return invalid(i, s, "component index")
+			}
+		}
+
+		if s.Ss > s.Se || s.Se > 63 {
+			return invalid(i, s, "spectral selection")
+		}
+		if s.Ss == 0 && s.Se != 0 {
+			return invalid(i, s, "DC and AC coefficients in a scan")
+		}
+		if s.Ss != 0 && len(s.Components) != 1 {
+			return invalid(i, s, "AC scan of multiple components")
+		}
+		if s.Ah > 13 || s.Al > 13 {
+			return invalid(i, s, "successive approximation")
+		}
+
+		for _, c := range s.Components {
+			if s.Ss != 0 && last[c][0] < 0 {
+				return invalid(i, s, "AC scan before DC scan")
+			}
+
+			for k := int(s.Ss); k <= int(s.Se); k++ {
+				if last[c][k] < 0 {
+					if s.Ah != 0 {
+						return invalid(i, s, "refinement of uncoded coefficients")
+					}
+				} else if int(s.Ah) != last[c][k] || s.Al+1 != s.Ah {
+					return invalid(i, s, "successive approximation")
+				}
+				last[c][k] = int(s.Al)
+			}
+		}
+	}
+
+	for c := range last {
+		if last[c][0] < 0 {
+			slog.Error("invalid scan script", "component", c, "reason", "DC coefficients not coded")
+			return ErrInvalidScanScript
+		}
+	}
+
+	return nil
+}
+
+// progressiveScans returns the scans of the script for the frame.
+func progressiveScans(h *frame, script []Scan) ([]*scan, error) {
+	if script == nil {
+		script = SimpleProgression(len(h.comps))
+	}
+
+	if err := validateScans(script, len(h.comps)); err != nil {
+		return nil, err
+	}
+
+	var ret []*scan
+	for _, s := range script {
+		sc := &scan{ss: s.Ss, se: s.Se, ah: s.Ah, al: s.Al}
+		for _, c := range s.Components {
+			sc.comps = append(sc.comps, h.comps[c])
+		}
+		ret = append(ret, sc)
+	}
+
+	return ret, nil
+}
+
+// encodeDCFirst encodes the first scan of the DC coefficient (G.1.2.1).
+func (e *Encoder) encodeDCFirst(c *component, zz *[64]int32, al uint8) {
+	dc := zz[0] >> al
+	diff := dc - c.pred
+	c.pred = dc
+	e.writeValue(c.dc, 0, diff)
+}
+
+// encodeDCRefine encodes the refinement scan of the DC coefficient.
+func (e *Encoder) encodeDCRefine(zz *[64]int32, al uint8) {
+	e.writeBits(uint32(zz[0]>>al), 1)
+}
+
+// writeEOBRun writes the EOB run and the correction bits of the blocks in the run.
+func (e *Encoder) writeEOBRun(t *hufftable) {
+	n := category(int32(e.eobrun)) - 1
+	e.writeHuffman(t, n<<4)
+	e.writeBits(uint32(e.eobrun), n)
+	e.eobrun = 0
+
+	e.writeCorrBits(e.corrBits)
+	e.corrBits = e.corrBits[:0]
+}
+
+func (e *Encoder) writeCorrBits(bits []uint8) {
+	for _, b := range bits {
+		e.writeBits(uint32(b), 1)
+	}
+}
+
+// encodeACFirst encodes the first scan of the AC coefficients of the spectral band (G.1.2.2).
+func (e *Encoder) encodeACFirst(c *component, zz *[64]int32, ss, se, al uint8) {
+	run := 0
+	for k := int(ss); k <= int(se); k++ {
+		v := zz[k]
+		if v < 0 {
+			v = -(-v >> al)
+		} else {
+			v >>= al
+		}
+		if v == 0 {
+			run++
+			continue
+		}
+
+		if e.eobrun > 0 {
+			e.writeEOBRun(c.ac)
+		}
+		for run > 15 {
+			e.writeHuffman(c.ac, 0xF0)
+			run -= 16
+		}
+		e.writeValue(c.ac, uint8(run<<4), v)
+		run = 0
+	}
+
+	if run > 0 {
+		e.eobrun++
+		if e.eobrun == 0x7FFF {
+			e.writeEOBRun(c.ac)
+		}
+	}
+}
+
+// encodeACRefine encodes the refinement scan of the AC coefficients of the spectral band (G.1.2.3).
+func (e *Encoder) encodeACRefine(c *component, zz *[64]int32, ss, se, al uint8) {
+	var abs [64]int32
+	eob := 0 // the last coefficient which becomes non-zero
+	for k := int(ss); k <= int(se); k++ {
+		v := zz[k]
+		if v < 0 {
+			v = -v
+		}
+		abs[k] = v >> al
+		if abs[k] == 1 {
+			eob = k
+		}
+	}
+
+	run := 0
+	var br []uint8 // correction bits of the coefficients coded in this block
+	for k := int(ss); k <= int(se); k++ {
+		v := abs[k]
+		if v == 0 {
+			run++
+			continue
+		}
+
+		// ZRLs after the last newly non-zero coefficient are folded into EOB
+		for run > 15 && k <= eob {
+			if e.eobrun > 0 {
+				e.writeEOBRun(c.ac)
+			}
+			e.writeHuffman(c.ac, 0xF0)
+			run -= 16
+			e.writeCorrBits(br)
+			br = br[:0]
+		}
+
+		if v > 1 {
+			// previously non-zero coefficient
+			br = append(br, uint8(v&1))
+			continue
+		}
+
+		if e.eobrun > 0 {
+			e.writeEOBRun(c.ac)
+		}
+		e.writeHuffman(c.ac, uint8(run<<4)|1)
+		if zz[k] < 0 {
+			e.writeBits(0, 1)
+		} else {
+			e.writeBits(1, 1)
+		}
+		e.writeCorrBits(br)
+		br = br[:0]
+		run = 0
+	}
+
+	if run > 0 || len(br) > 0 {
+		e.eobrun++
+		e.corrBits = append(e.corrBits, br...)
+		if e.eobrun == 0x7FFF || len(e.corrBits) > maxCorrBits-64+1 {
+			e.writeEOBRun(c.ac)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package encoder
+
+import (
+	"fmt"
+
+	"github.com/yunomu/jpeg/jpeg"
+)
+
+// Tables of Annex K.1 in natural order.
+var (
+	stdLuma = [64]uint16{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	}
+	stdChroma = [64]uint16{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+)
+
+type quantizationTable struct {
+	pq, tq uint8
+	qs     [64]uint16 // zigzag order
+}
+
+func (t *quantizationTable) String() string {
+	return fmt.Sprintf("(Pq=%d Tq=%d Q=%v)", t.pq, t.tq, t.qs)
+}
+
+// scaleTable scales the table by the IJG quality factor, limiting the values to maxq.
+func scaleTable(t *[64]uint16, quality, maxq int) [64]uint16 {
+	s := 200 - quality*2
+	if quality < 50 {
+		s = 5000 / quality
+	}
+
+	var ret [64]uint16
+	for i, q := range t {
+		ret[i] = uint16(min(max((int(q)*s+50)/100, 1), maxq))
+	}
+
+	return ret
+}
+
+// newQuantizationTable returns the table of the precision pq,
+// 0 for 8-bit values and 1 for 16-bit values.
+func newQuantizationTable(pq, tq uint8, t *[64]uint16) (*quantizationTable, error) {
+	ret := &quantizationTable{pq: pq, tq: tq}
+	for i, q := range t {
+		if q == 0 || (pq == 0 && q > 255) {
+			return nil, ErrInvalidQuantizationTable
+		}
+		ret.qs[jpeg.Unzig[i]] = q
+	}
+
+	return ret, nil
+}
+
+// quantizationTables returns the luminance and chrominance tables of the options
+// for the sample precision p. The tables of 12-bit images have 16-bit values.
+func quantizationTables(opts *Options, p uint8) (*quantizationTable, *quantizationTable, error) {
+	quality := opts.Quality
+	if quality == 0 {
+		quality = DefaultQuality
+	}
+	chromaQuality := opts.ChromaQuality
+	if chromaQuality == 0 {
+		chromaQuality = quality
+	}
+	if quality < 1 || quality > 100 || chromaQuality < 1 || chromaQuality > 100 {
+		return nil, nil, ErrInvalidQuality
+	}
+
+	baseLuma, baseChroma, err := opts.TableSet.tables()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pq uint8
+	maxq := 255
+	if p == 12 {
+		pq = 1
+		maxq = 32767 // the same as libjpeg
+	}
+
+	luma, chroma := scaleTable(baseLuma, quality, maxq), scaleTable(baseChroma, chromaQuality, maxq)
+	if opts.LumaTable != nil {
+		luma = *opts.LumaTable
+	}
+	if opts.ChromaTable != nil {
+		chroma = *opts.ChromaTable
+	}
+
+	lt, err := newQuantizationTable(pq, 0, &luma)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ct, err := newQuantizationTable(pq, 1, &chroma)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return lt, ct, nil
+}
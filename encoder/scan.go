@@ -0,0 +1,217 @@
+package encoder
+
+import (
+	"fmt"
+
+	"github.com/yunomu/jpeg/decoder"
+	"github.com/yunomu/jpeg/jpeg"
+)
+
+// transform computes the DCT coefficients of all the blocks of the component
+// of the precision p with fdct.
+func (c *component) transform(p uint8, fdct fdctFunc) {
+	c.dcts = make([][64]float64, c.bw*c.bh)
+	var b [64]int32
+	var f [64]float64
+	for by := 0; by < c.bh; by++ {
+		for bx := 0; bx < c.bw; bx++ {
+			c.block(bx, by, p, &b)
+			fdct(&b, &f, p)
+			d := &c.dcts[by*c.bw+bx]
+			for i, k := range jpeg.Unzig {
+				d[k] = f[i]
+			}
+		}
+	}
+}
+
+// quantize quantizes the DCT coefficients of all the blocks of the component with quant.
+func (c *component) quantize(quant quantizeFunc) {
+	if len(c.coefs) != len(c.dcts) {
+		c.coefs = make([][64]int32, len(c.dcts))
+	}
+	for i, d := range c.dcts {
+		for k, f := range d {
+			c.coefs[i][k] = quant(f, k, c.qt.qs[k])
+		}
+	}
+}
+
+// category returns the number of bits of the magnitude of v (F.1.2.1).
+func category(v int32) uint8 {
+	if v < 0 {
+		v = -v
+	}
+
+	var ret uint8
+	for v > 0 {
+		ret++
+		v >>= 1
+	}
+	return ret
+}
+
+// writeValue writes the category of v coded by the table and the additional bits.
+func (e *Encoder) writeValue(t *hufftable, rs uint8, v int32) {
+	s := category(v)
+	e.writeHuffman(t, rs|s)
+	if v < 0 {
+		v--
+	}
+	e.writeBits(uint32(v), s)
+}
+
+// encodeBlock encodes the coefficients of a data unit with the Huffman tables (F.1.2).
+func (e *Encoder) encodeBlock(c *component, zz *[64]int32) {
+	diff := zz[0] - c.pred
+	c.pred = zz[0]
+	e.writeValue(c.dc, 0, diff)
+
+	run := 0
+	for k := 1; k < 64; k++ {
+		if zz[k] == 0 {
+			run++
+			continue
+		}
+
+		for run > 15 {
+			e.writeHuffman(c.ac, 0xF0)
+			run -= 16
+		}
+		e.writeValue(c.ac, uint8(run<<4), zz[k])
+		run = 0
+	}
+	if run > 0 {
+		e.writeHuffman(c.ac, 0x00)
+	}
+}
+
+// scan is the parameters of a scan.
+type scan struct {
+	comps          []*component
+	ss, se, ah, al uint8
+}
+
+func (s *scan) String() string {
+	return fmt.Sprintf("(Ns=%d comps=%v Ss=%d Se=%d Ah=%d Al=%d)", len(s.comps), s.comps, s.ss, s.se, s.ah, s.al)
+}
+
+// hufftables returns the Huffman tables used in the scan.
+func (s *scan) hufftables(progressive bool) []*hufftable {
+	var ret []*hufftable
+	add := func(t *hufftable) {
+		if t == nil {
+			return
+		}
+		for _, t1 := range ret {
+			if t1 == t {
+				return
+			}
+		}
+		ret = append(ret, t)
+	}
+
+	for _, c := range s.comps {
+		switch {
+		case !progressive:
+			add(c.dc)
+			add(c.ac)
+		case s.ss == 0 && s.ah == 0:
+			add(c.dc)
+		case s.ss != 0:
+			add(c.ac)
+		}
+	}
+
+	return ret
+}
+
+// writeRST ends the restart interval.
+func (e *Encoder) writeRST(n int, comps []*component) {
+	if e.eobrun > 0 {
+		e.writeEOBRun(comps[0].ac)
+	}
+	if !e.counting {
+		e.flushBits()
+		e.writeMarker(decoder.Marker_RST_0 + decoder.Marker(n%8))
+	}
+	for _, c := range comps {
+		c.pred = 0
+	}
+}
+
+// encodeUnit encodes the data unit of the component in the scan.
+func (e *Encoder) encodeUnit(s *scan, c *component, zz *[64]int32) {
+	if e.arithmetic {
+		e.encodeArithUnit(s, c, zz)
+		return
+	}
+
+	switch {
+	case !e.progressive:
+		e.encodeBlock(c, zz)
+	case s.ss == 0 && s.ah == 0:
+		e.encodeDCFirst(c, zz, s.al)
+	case s.ss == 0:
+		e.encodeDCRefine(zz, s.al)
+	case s.ah == 0:
+		e.encodeACFirst(c, zz, s.ss, s.se, s.al)
+	default:
+		e.encodeACRefine(c, zz, s.ss, s.se, s.al)
+	}
+}
+
+// encodeScan encodes the data units of the scan. The MCU of a scan of a single
+// component is a data unit, and the data units are not padded to the MCU of the frame.
+func (e *Encoder) encodeScan(h *frame, s *scan, interval int) {
+	for _, c := range s.comps {
+		c.pred = 0
+	}
+	e.eobrun = 0
+	e.corrBits = e.corrBits[:0]
+	if e.arithmetic {
+		e.resetArith(s)
+	}
+
+	mcux, mcuy := h.mcuX, h.mcuY
+	if len(s.comps) == 1 {
+		c := s.comps[0]
+		mcux, mcuy = (c.x+7)/8, (c.y+7)/8
+	}
+
+	n := 0
+	for my := 0; my < mcuy; my++ {
+		for mx := 0; mx < mcux; mx++ {
+			if interval > 0 && n > 0 && n%interval == 0 {
+				if e.arithmetic {
+					e.writeArithRST(n/interval-1, s)
+				} else {
+					e.writeRST(n/interval-1, s.comps)
+				}
+			}
+			n++
+
+			if len(s.comps) == 1 {
+				c := s.comps[0]
+				e.encodeUnit(s, c, c.coef(mx, my))
+				continue
+			}
+
+			for _, c := range s.comps {
+				for v := 0; v < int(c.v); v++ {
+					for u := 0; u < int(c.h); u++ {
+						e.encodeUnit(s, c, c.coef(mx*int(c.h)+u, my*int(c.v)+v))
+					}
+				}
+			}
+		}
+	}
+	if e.arithmetic {
+		e.finishArith()
+		return
+	}
+	if e.eobrun > 0 {
+		e.writeEOBRun(s.comps[0].ac)
+	}
+	e.flushBits()
+}
@@ -0,0 +1,192 @@
+package encoder
+
+import "errors"
+
+var ErrInvalidTableSet = errors.New("invalid table set")
+
+// TableSet is a set of the base luminance and chrominance quantization tables
+// scaled by the quality. The sets are those of mozjpeg and numbered the same as
+// its -quant-table option.
+type TableSet int
+
+const (
+	TableSetAnnexK      TableSet = iota // Annex K.1 of T.81
+	TableSetFlat                        // all 16
+	TableSetMSSSIM                      // tuned for MS-SSIM
+	TableSetImageMagick                 // by N. Robidoux for ImageMagick
+	TableSetPSNRHVS                     // tuned for PSNR-HVS
+	TableSetKlein                       // Klein, Silverstein and Carney (1992)
+	TableSetWatson                      // DCTune, Watson, Taylor and Borthwick (1997)
+	TableSetAhumada                     // Ahumada, Watson, Peterson and Peterson (1993)
+	TableSetPeterson                    // Peterson, Ahumada and Watson (1993)
+)
+
+func (s TableSet) String() string {
+	switch s {
+	case TableSetAnnexK:
+		return "AnnexK"
+	case TableSetFlat:
+		return "Flat"
+	case TableSetMSSSIM:
+		return "MS-SSIM"
+	case TableSetImageMagick:
+		return "ImageMagick"
+	case TableSetPSNRHVS:
+		return "PSNR-HVS"
+	case TableSetKlein:
+		return "Klein"
+	case TableSetWatson:
+		return "Watson"
+	case TableSetAhumada:
+		return "Ahumada"
+	case TableSetPeterson:
+		return "Peterson"
+	}
+	return "unknown"
+}
+
+// tables returns the base luminance and chrominance tables of the set in natural order.
+func (s TableSet) tables() (*[64]uint16, *[64]uint16, error) {
+	switch s {
+	case TableSetAnnexK:
+		return &stdLuma, &stdChroma, nil
+	case TableSetFlat:
+		return &flatTable, &flatTable, nil
+	case TableSetMSSSIM:
+		return &msssimLuma, &msssimChroma, nil
+	case TableSetImageMagick:
+		return &imageMagickTable, &imageMagickTable, nil
+	case TableSetPSNRHVS:
+		return &psnrhvsLuma, &psnrhvsChroma, nil
+	case TableSetKlein:
+		return &kleinTable, &kleinTable, nil
+	case TableSetWatson:
+		return &watsonTable, &watsonTable, nil
+	case TableSetAhumada:
+		return &ahumadaTable, &ahumadaTable, nil
+	case TableSetPeterson:
+		return &petersonTable, &petersonTable, nil
+	}
+	return nil, nil, ErrInvalidTableSet
+}
+
+// QuantizationTables returns the luminance and chrominance tables of the set
+// scaled by the IJG quality factors from 1 to 100, in natural order.
+// The values are limited to 255 for baseline images.
+func QuantizationTables(s TableSet, lumaQuality, chromaQuality int) (*[64]uint16, *[64]uint16, error) {
+	if lumaQuality < 1 || lumaQuality > 100 || chromaQuality < 1 || chromaQuality > 100 {
+		return nil, nil, ErrInvalidQuality
+	}
+
+	luma, chroma, err := s.tables()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lt, ct := scaleTable(luma, lumaQuality, 255), scaleTable(chroma, chromaQuality, 255)
+	return &lt, &ct, nil
+}
+
+// Base tables of mozjpeg in natural order.
+var (
+	flatTable = [64]uint16{
+		16, 16, 16, 16, 16, 16, 16, 16,
+		16, 16, 16, 16, 16, 16, 16, 16,
+		16, 16, 16, 16, 16, 16, 16, 16,
+		16, 16, 16, 16, 16, 16, 16, 16,
+		16, 16, 16, 16, 16, 16, 16, 16,
+		16, 16, 16, 16, 16, 16, 16, 16,
+		16, 16, 16, 16, 16, 16, 16, 16,
+		16, 16, 16, 16, 16, 16, 16, 16,
+	}
+	msssimLuma = [64]uint16{
+		12, 17, 20, 21, 30, 34, 56, 63,
+		18, 20, 20, 26, 28, 51, 61, 55,
+		19, 20, 21, 26, 33, 58, 69, 55,
+		26, 26, 26, 30, 46, 87, 86, 66,
+		31, 33, 36, 40, 46, 96, 100, 73,
+		40, 35, 46, 62, 81, 100, 111, 91,
+		46, 66, 76, 86, 102, 121, 120, 101,
+		68, 90, 90, 96, 113, 102, 105, 103,
+	}
+	msssimChroma = [64]uint16{
+		8, 12, 15, 15, 86, 96, 96, 98,
+		13, 13, 15, 26, 90, 96, 99, 98,
+		12, 15, 18, 96, 99, 99, 99, 99,
+		17, 16, 90, 96, 99, 99, 99, 99,
+		96, 96, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+	imageMagickTable = [64]uint16{
+		16, 16, 16, 18, 25, 37, 56, 85,
+		16, 17, 20, 27, 34, 40, 53, 75,
+		16, 20, 24, 31, 43, 62, 91, 135,
+		18, 27, 31, 40, 53, 74, 106, 156,
+		25, 34, 43, 53, 69, 94, 131, 189,
+		37, 40, 62, 74, 94, 124, 169, 238,
+		56, 53, 91, 106, 131, 169, 226, 311,
+		85, 75, 135, 156, 189, 238, 311, 418,
+	}
+	psnrhvsLuma = [64]uint16{
+		9, 10, 12, 14, 27, 32, 51, 62,
+		11, 12, 14, 19, 27, 44, 59, 73,
+		12, 14, 18, 25, 42, 59, 79, 78,
+		17, 18, 25, 42, 61, 92, 87, 92,
+		23, 28, 42, 75, 79, 112, 112, 99,
+		40, 42, 59, 84, 88, 124, 132, 111,
+		42, 64, 78, 95, 105, 126, 125, 99,
+		70, 75, 100, 102, 116, 100, 107, 98,
+	}
+	psnrhvsChroma = [64]uint16{
+		9, 10, 17, 19, 62, 89, 91, 97,
+		12, 13, 18, 29, 84, 91, 88, 98,
+		14, 19, 29, 93, 95, 95, 98, 97,
+		20, 26, 84, 88, 95, 95, 98, 94,
+		26, 86, 91, 93, 97, 99, 98, 99,
+		99, 100, 98, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		97, 97, 99, 99, 99, 99, 97, 99,
+	}
+	kleinTable = [64]uint16{
+		10, 12, 14, 19, 26, 38, 57, 86,
+		12, 18, 21, 28, 35, 41, 54, 76,
+		14, 21, 25, 32, 44, 63, 92, 136,
+		19, 28, 32, 41, 54, 75, 107, 157,
+		26, 35, 44, 54, 70, 95, 132, 190,
+		38, 41, 63, 75, 95, 125, 170, 239,
+		57, 54, 92, 107, 132, 170, 227, 312,
+		86, 76, 136, 157, 190, 239, 312, 419,
+	}
+	watsonTable = [64]uint16{
+		7, 8, 10, 14, 23, 44, 95, 241,
+		8, 8, 11, 15, 25, 47, 102, 255,
+		10, 11, 13, 19, 31, 58, 127, 255,
+		14, 15, 19, 27, 44, 83, 181, 255,
+		23, 25, 31, 44, 72, 136, 255, 255,
+		44, 47, 58, 83, 136, 255, 255, 255,
+		95, 102, 127, 181, 255, 255, 255, 255,
+		241, 255, 255, 255, 255, 255, 255, 255,
+	}
+	ahumadaTable = [64]uint16{
+		15, 11, 11, 12, 15, 19, 25, 32,
+		11, 13, 10, 10, 12, 15, 19, 24,
+		11, 10, 14, 14, 16, 18, 22, 27,
+		12, 10, 14, 18, 21, 24, 28, 33,
+		15, 12, 16, 21, 26, 31, 36, 42,
+		19, 15, 18, 24, 31, 38, 45, 53,
+		25, 19, 22, 28, 36, 45, 55, 65,
+		32, 24, 27, 33, 42, 53, 65, 77,
+	}
+	petersonTable = [64]uint16{
+		14, 10, 11, 14, 19, 25, 34, 45,
+		10, 11, 11, 12, 15, 20, 26, 33,
+		11, 11, 15, 18, 21, 25, 31, 38,
+		14, 12, 18, 24, 28, 33, 39, 47,
+		19, 15, 21, 28, 36, 43, 51, 59,
+		25, 20, 25, 33, 43, 54, 64, 74,
+		34, 26, 31, 39, 51, 64, 77, 91,
+		45, 33, 38, 47, 59, 74, 91, 108,
+	}
+)
@@ -0,0 +1,113 @@
+package encoder
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/yunomu/jpeg/decoder"
+	"github.com/yunomu/jpeg/jpeg"
+)
+
+func TestQuantizationTables(t *testing.T) {
+	luma, chroma, err := QuantizationTables(TableSetAnnexK, 50, 100)
+	if err != nil {
+		t.Fatalf("QuantizationTables: %v", err)
+	}
+	if *luma != stdLuma {
+		t.Errorf("luma: %v", luma)
+	}
+	for i, q := range chroma {
+		if q != 1 {
+			t.Errorf("chroma: Q[%d]=%d", i, q)
+		}
+	}
+
+	luma, _, err = QuantizationTables(TableSetFlat, 75, 75)
+	if err != nil {
+		t.Fatalf("QuantizationTables: %v", err)
+	}
+	for i, q := range luma {
+		if q != 8 {
+			t.Errorf("flat: Q[%d]=%d", i, q)
+		}
+	}
+
+	// limited to 255
+	luma, _, err = QuantizationTables(TableSetImageMagick, 50, 50)
+	if err != nil {
+		t.Fatalf("QuantizationTables: %v", err)
+	}
+	if luma[63] != 255 {
+		t.Errorf("ImageMagick: Q[63]=%d", luma[63])
+	}
+
+	for _, c := range []struct {
+		s            TableSet
+		luma, chroma int
+		err          error
+	}{
+		{TableSetPeterson + 1, 50, 50, ErrInvalidTableSet},
+		{-1, 50, 50, ErrInvalidTableSet},
+		{TableSetAnnexK, 0, 50, ErrInvalidQuality},
+		{TableSetAnnexK, 50, 101, ErrInvalidQuality},
+	} {
+		if _, _, err := QuantizationTables(c.s, c.luma, c.chroma); err != c.err {
+			t.Errorf("%v %d %d: err=%v exp=%v", c.s, c.luma, c.chroma, err, c.err)
+		}
+	}
+}
+
+// dqt returns the values of the tables of the first DQT segment in natural order.
+func dqt(t *testing.T, data []byte) [][64]uint16 {
+	t.Helper()
+
+	i := bytes.Index(data, []byte{0xFF, 0xDB})
+	if i < 0 {
+		t.Fatalf("no DQT")
+	}
+	n := int(data[i+2])<<8 | int(data[i+3])
+	seg := data[i+4 : i+2+n]
+
+	var ret [][64]uint16
+	for len(seg) > 0 {
+		var qs [64]uint16
+		for k := range qs {
+			qs[k] = uint16(seg[1+jpeg.Unzig[k]])
+		}
+		ret = append(ret, qs)
+		seg = seg[65:]
+	}
+	return ret
+}
+
+func TestEncode_tableSet(t *testing.T) {
+	src := testImage(37, 29)
+
+	for s := TableSetAnnexK; s <= TableSetPeterson; s++ {
+		opts := &Options{TableSet: s, Quality: 90, ChromaQuality: 70}
+		data := encode(t, src, opts)
+
+		luma, chroma, err := QuantizationTables(s, 90, 70)
+		if err != nil {
+			t.Fatalf("%v: QuantizationTables: %v", s, err)
+		}
+		if qts := dqt(t, data); len(qts) != 2 || qts[0] != *luma || qts[1] != *chroma {
+			t.Errorf("%v: DQT=%v", s, qts)
+		}
+
+		img, err := decoder.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%v: Decode: %v", s, err)
+		}
+		if e := meanError(t, src, img); e > 5 {
+			t.Errorf("%v: mean error=%v", s, e)
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, src, &Options{TableSet: 9}); err != ErrInvalidTableSet {
+		t.Errorf("err=%v", err)
+	}
+	if err := Encode(&bytes.Buffer{}, src, &Options{ChromaQuality: 101}); err != ErrInvalidQuality {
+		t.Errorf("err=%v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package encoder
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log/slog"
+	"math"
+)
+
+var ErrTargetSize = errors.New("output exceeds the maximum size at the lowest quality")
+
+// SearchResult is the parameters chosen by the search of MaxSize and TargetPSNR.
+type SearchResult struct {
+	Quality       int
+	ChromaQuality int
+	Size          int     // size of the output in bytes
+	PSNR          float64 // PSNR of the samples in dB
+	Passes        int     // number of the qualities tried
+}
+
+// Result returns the parameters chosen by the last Encode, or nil if it did not search.
+func (e *Encoder) Result() *SearchResult {
+	return e.result
+}
+
+// psnr returns the PSNR of the samples of the quantized coefficients of the frame.
+// It is computed from the errors of the coefficients as the DCT is orthonormal,
+// without the blocks of padding.
+func (h *frame) psnr() float64 {
+	var sse float64
+	n := 0
+	for _, c := range h.comps {
+		for by := 0; by < (c.y+7)/8; by++ {
+			for bx := 0; bx < (c.x+7)/8; bx++ {
+				i := by*c.bw + bx
+				for k, f := range c.dcts[i] {
+					d := f - float64(c.coefs[i][k])*float64(c.qt.qs[k])
+					sse += d * d
+				}
+				n += 64
+			}
+		}
+	}
+	if sse == 0 {
+		return math.Inf(1)
+	}
+
+	peak := float64(int(1)<<h.p - 1)
+	return 10 * math.Log10(peak*peak*float64(n)/sse)
+}
+
+// requantize quantizes the coefficients of the frame with the quality and returns
+// the quality of the chrominance.
+func (e *Encoder) requantize(h *frame, quality int) (int, error) {
+	opts := e.opts
+	opts.Quality = quality
+	if e.opts.ChromaQuality != 0 {
+		base := e.opts.Quality
+		if base == 0 {
+			base = DefaultQuality
+		}
+		opts.ChromaQuality = min(max(quality+e.opts.ChromaQuality-base, 1), 100)
+	}
+
+	luma, chroma, err := quantizationTables(&opts, h.p)
+	if err != nil {
+		return 0, err
+	}
+
+	h.setHufftables()
+	h.quantize(luma, chroma)
+	if e.opts.Trellis {
+		if err := e.trellisQuantize(h); err != nil {
+			return 0, err
+		}
+	}
+
+	chromaQuality := opts.ChromaQuality
+	if chromaQuality == 0 {
+		chromaQuality = quality
+	}
+	return chromaQuality, nil
+}
+
+// encodeTarget searches the quality for MaxSize and TargetPSNR with binary searches,
+// and writes the stream of the chosen quality. The DCT coefficients of the frame
+// are quantized again for each quality.
+func (e *Encoder) encodeTarget(h *frame) error {
+	w := e.w
+	defer func() {
+		e.w = w
+	}()
+
+	result := &SearchResult{}
+	var buf bytes.Buffer
+	try := func(quality int, encode bool) error {
+		result.Passes++
+		chromaQuality, err := e.requantize(h, quality)
+		if err != nil {
+			return err
+		}
+		result.Quality, result.ChromaQuality = quality, chromaQuality
+		result.PSNR = h.psnr()
+
+		if !encode {
+			return nil
+		}
+		buf.Reset()
+		e.w = bufio.NewWriter(&buf)
+		if err := e.encodeFrame(h); err != nil {
+			return err
+		}
+		result.Size = buf.Len()
+		slog.Debug("target search", "quality", quality, "size", result.Size, "PSNR", result.PSNR)
+		return nil
+	}
+
+	// the lowest quality reaching the PSNR, which needs no entropy coding
+	hi := 100
+	if e.opts.TargetPSNR > 0 {
+		lo := 1
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if err := try(mid, false); err != nil {
+				return err
+			}
+			if result.PSNR >= e.opts.TargetPSNR {
+				hi = mid
+			} else {
+				lo = mid + 1
+			}
+		}
+	}
+
+	// the highest quality within the size
+	quality := hi
+	if e.opts.MaxSize > 0 {
+		if err := try(hi, true); err != nil {
+			return err
+		}
+		if result.Size > e.opts.MaxSize {
+			if err := try(1, true); err != nil {
+				return err
+			}
+			if result.Size > e.opts.MaxSize {
+				return ErrTargetSize
+			}
+
+			lo := 1
+			for lo < hi-1 {
+				mid := (lo + hi) / 2
+				if err := try(mid, true); err != nil {
+					return err
+				}
+				if result.Size <= e.opts.MaxSize {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			quality = lo
+		}
+	}
+
+	if result.Quality != quality || result.Size == 0 {
+		if err := try(quality, true); err != nil {
+			return err
+		}
+	}
+	e.result = result
+
+	slog.Info("target search",
+		"quality", result.Quality,
+		"chromaQuality", result.ChromaQuality,
+		"size", result.Size,
+		"PSNR", result.PSNR,
+		"passes", result.Passes,
+	)
+
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return w.Flush()
+}
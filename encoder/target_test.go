@@ -0,0 +1,99 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"testing"
+
+	"github.com/yunomu/jpeg/decoder"
+)
+
+func encodeTarget(t *testing.T, m image.Image, opts *Options) ([]byte, *SearchResult) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	e := New(&buf, opts)
+	if err := e.Encode(m); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if e.Result() == nil {
+		t.Fatalf("no result")
+	}
+	return buf.Bytes(), e.Result()
+}
+
+func TestEncode_maxSize(t *testing.T) {
+	src := testTexture(64, 48)
+
+	for _, opts := range []Options{
+		{MaxSize: 1500},
+		{MaxSize: 1500, ChromaQuality: 50},
+		{MaxSize: 2500, Trellis: true, OptimizeHuffman: true},
+		{MaxSize: 2500, Progressive: true},
+	} {
+		data, r := encodeTarget(t, src, &opts)
+		if len(data) > opts.MaxSize || r.Size != len(data) {
+			t.Errorf("%+v: size=%d result=%+v", opts, len(data), r)
+		}
+
+		// the same as the chosen quality
+		fixed := opts
+		fixed.MaxSize = 0
+		fixed.Quality, fixed.ChromaQuality = r.Quality, r.ChromaQuality
+		if !bytes.Equal(encode(t, src, &fixed), data) {
+			t.Errorf("%+v: output differs from quality %d", opts, r.Quality)
+		}
+
+		if r.Quality == 1 || r.Passes > 10 {
+			t.Errorf("%+v: result=%+v", opts, r)
+		}
+	}
+
+	if err := Encode(&bytes.Buffer{}, src, &Options{MaxSize: 100}); err != ErrTargetSize {
+		t.Errorf("err=%v", err)
+	}
+	if err := Encode(&bytes.Buffer{}, src, &Options{MaxSize: 1000, Lossless: true}); err != ErrUnsupportedOptions {
+		t.Errorf("err=%v", err)
+	}
+}
+
+func TestEncode_targetPSNR(t *testing.T) {
+	src := testTexture(64, 48)
+	gray := image.NewGray(src.Bounds())
+	for i := range gray.Pix {
+		gray.Pix[i] = src.Pix[i*4]
+	}
+
+	for _, target := range []float64{30, 35, 40} {
+		data, r := encodeTarget(t, gray, &Options{TargetPSNR: target})
+		if r.PSNR < target {
+			t.Errorf("%v: result=%+v", target, r)
+		}
+		if !bytes.Equal(encode(t, gray, &Options{Quality: r.Quality}), data) {
+			t.Errorf("%v: output differs from quality %d", target, r.Quality)
+		}
+
+		// the PSNR of the decoded samples
+		img, err := decoder.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		var sse float64
+		for i, v := range img.(*image.Gray).Pix {
+			d := float64(v) - float64(gray.Pix[i])
+			sse += d * d
+		}
+		psnr := 10 * math.Log10(255*255*float64(len(gray.Pix))/sse)
+		if math.Abs(psnr-r.PSNR) > 0.5 {
+			t.Errorf("%v: PSNR=%v result=%+v", target, psnr, r)
+		}
+
+		if r.Quality > 1 {
+			_, r1 := encodeTarget(t, gray, &Options{TargetPSNR: target, MaxSize: len(data) - 1})
+			if r1.Quality >= r.Quality {
+				t.Errorf("%v: quality within size=%d", target, r1.Quality)
+			}
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package encoder
+
+import "math"
+
+// DefaultTrellisLambda is the default of Options.TrellisLambda, lambda_log_scale1 of mozjpeg.
+const DefaultTrellisLambda = 14.75
+
+// trellisLambdaScale2 is lambda_log_scale2 of mozjpeg, which lowers lambda for
+// the blocks of high energy.
+const trellisLambdaScale2 = 16.5
+
+// dctScale is the scale of the DCT coefficients of libjpeg, in which the
+// constants of mozjpeg are tuned.
+const dctScale = 8
+
+// codeSizes returns the sizes of the codes of the table as the rates of the symbols.
+// The symbols without codes are estimated as the longest codes.
+func codeSizes(t *hufftable) *[256]float64 {
+	var ret [256]float64
+	for s, c := range t.codes {
+		ret[s] = float64(c.Size)
+		if c.Size == 0 {
+			ret[s] = 16
+		}
+	}
+	return &ret
+}
+
+// trellisBlock is a block with the scaled DCT coefficients in zigzag order.
+type trellisBlock struct {
+	src    [64]float64
+	zz     *[64]int32
+	lambda float64
+}
+
+func newTrellisBlock(c *component, bx, by int, scale float64) *trellisBlock {
+	b := &trellisBlock{zz: c.coef(bx, by)}
+	for k, f := range c.dcts[by*c.bw+bx] {
+		b.src[k] = f * dctScale
+	}
+
+	// lambda adapted to the energy of the AC coefficients
+	var norm float64
+	for k := 1; k < 64; k++ {
+		norm += b.src[k] * b.src[k]
+	}
+	norm /= 63
+	b.lambda = math.Exp2(scale) / (math.Exp2(trellisLambdaScale2) + norm)
+
+	return b
+}
+
+// dist returns the weighted distortion of the coefficient k quantized to v.
+func (b *trellisBlock) dist(qt *quantizationTable, k int, v int32) float64 {
+	q := float64(qt.qs[k])
+	d := b.src[k] - float64(v)*q*dctScale
+	return d * d * b.lambda / (q * q)
+}
+
+// quantizeAC quantizes the AC coefficients of the block to the values of the least cost
+// of the rate and the distortion, searching the runs of zeros and the magnitude
+// categories of the coefficients as in mozjpeg.
+func (b *trellisBlock) quantizeAC(qt *quantizationTable, rates *[256]float64) {
+	// zd[k] is the distortion of the coefficients from 1 to k quantized to zero
+	var zd [64]float64
+	for k := 1; k < 64; k++ {
+		zd[k] = zd[k-1] + b.dist(qt, k, 0)
+	}
+
+	// cost[k] is the least cost of the coefficients to k with the last non-zero coefficient k
+	var cost [64]float64
+	var prev [64]int
+	var value [64]int32
+	for k := 1; k < 64; k++ {
+		cost[k] = math.Inf(1)
+
+		x := b.src[k]
+		qv := int32(math.Round(math.Abs(x) / (float64(qt.qs[k]) * dctScale)))
+		if qv == 0 {
+			continue
+		}
+
+		// the largest magnitude of each category has the least distortion in it
+		for s := uint8(1); s <= category(qv); s++ {
+			v := min(int32(1)<<s-1, qv)
+			if x < 0 {
+				v = -v
+			}
+			d := b.dist(qt, k, v)
+
+			for j := k - 1; j >= 0; j-- {
+				if math.IsInf(cost[j], 1) {
+					continue
+				}
+				run := k - j - 1
+				r := rates[0xF0]*float64(run/16) + rates[(run%16)<<4|int(s)] + float64(s)
+				if c := cost[j] + zd[k-1] - zd[j] + r + d; c < cost[k] {
+					cost[k] = c
+					prev[k] = j
+					value[k] = v
+				}
+			}
+		}
+	}
+
+	// the last non-zero coefficient followed by EOB
+	last, best := 0, zd[63]+rates[0x00]
+	for k := 1; k < 64; k++ {
+		c := cost[k] + zd[63] - zd[k]
+		if k < 63 {
+			c += rates[0x00]
+		}
+		if c < best {
+			last, best = k, c
+		}
+	}
+
+	for k := 1; k < 64; k++ {
+		b.zz[k] = 0
+	}
+	for k := last; k > 0; k = prev[k] {
+		b.zz[k] = value[k]
+	}
+}
+
+// quantizeDC quantizes the DC coefficients of the blocks in the order of the scan
+// to the values of the least cost of the rates of the differences and the distortion.
+// The candidates are the values rounded down and up.
+func quantizeDC(blocks []*trellisBlock, qt *quantizationTable, rates *[256]float64) {
+	if len(blocks) == 0 {
+		return
+	}
+
+	q := float64(qt.qs[0]) * dctScale
+	candidates := func(b *trellisBlock) []int32 {
+		lo, hi := math.Floor(b.src[0]/q), math.Ceil(b.src[0]/q)
+		if lo == hi {
+			return []int32{int32(lo)}
+		}
+		return []int32{int32(lo), int32(hi)}
+	}
+	rate := func(diff int32) float64 {
+		s := category(diff)
+		return rates[s] + float64(s)
+	}
+
+	// cost[i][j] is the least cost of the blocks to i with the candidate j of the block i
+	cost := make([][]float64, len(blocks))
+	prev := make([][]int, len(blocks))
+	values := make([][]int32, len(blocks))
+	for i, b := range blocks {
+		values[i] = candidates(b)
+		cost[i] = make([]float64, len(values[i]))
+		prev[i] = make([]int, len(values[i]))
+
+		for j, v := range values[i] {
+			d := b.dist(qt, 0, v)
+			if i == 0 {
+				cost[i][j] = rate(v) + d
+				continue
+			}
+
+			cost[i][j] = math.Inf(1)
+			for pj, pv := range values[i-1] {
+				if c := cost[i-1][pj] + rate(v-pv) + d; c < cost[i][j] {
+					cost[i][j] = c
+					prev[i][j] = pj
+				}
+			}
+		}
+	}
+
+	n := len(blocks) - 1
+	j := 0
+	for j1, c := range cost[n] {
+		if c < cost[n][j] {
+			j = j1
+		}
+	}
+	for i := n; i >= 0; i-- {
+		blocks[i].zz[0] = values[i][j]
+		j = prev[i][j]
+	}
+}
+
+// trellis requantizes the coefficients of the frame with the rates of its Huffman tables.
+// The DC coefficients are optimized along the order of the interleaved scan if dc is true.
+func (h *frame) trellis(scale float64, dc bool) {
+	for _, c := range h.comps {
+		rates := codeSizes(c.ac)
+
+		blocks := make([]*trellisBlock, c.bw*c.bh)
+		for by := 0; by < c.bh; by++ {
+			for bx := 0; bx < c.bw; bx++ {
+				b := newTrellisBlock(c, bx, by, scale)
+				b.quantizeAC(c.qt, rates)
+				blocks[by*c.bw+bx] = b
+			}
+		}
+		if !dc {
+			continue
+		}
+
+		order := blocks
+		if len(h.comps) > 1 {
+			order = make([]*trellisBlock, 0, len(blocks))
+			for my := 0; my < h.mcuY; my++ {
+				for mx := 0; mx < h.mcuX; mx++ {
+					for v := 0; v < int(c.v); v++ {
+						for u := 0; u < int(c.h); u++ {
+							order = append(order, blocks[(my*int(c.v)+v)*c.bw+mx*int(c.h)+u])
+						}
+					}
+				}
+			}
+		}
+		quantizeDC(order, c.qt, codeSizes(c.dc))
+	}
+}
+
+// trellisQuantize requantizes the coefficients of the frame with trellis quantization.
+// The rates are estimated with the Huffman tables of the sequential scan, which are
+// optimized for the coefficients of the first pass unless the tables of Annex K.3
+// are written.
+func (e *Encoder) trellisQuantize(h *frame) error {
+	scale := e.opts.TrellisLambda
+	if scale == 0 {
+		scale = DefaultTrellisLambda
+	}
+
+	h.trellis(scale, e.opts.TrellisDC)
+	if e.arithmetic || (!e.opts.OptimizeHuffman && !e.progressive && h.p == 8) {
+		return nil
+	}
+
+	// the statistics of the sequential scan
+	progressive := e.progressive
+	e.progressive = false
+	s := &scan{comps: h.comps, se: 63}
+	err := e.optimizeHufftables(h.hufftables(), func() {
+		e.encodeScan(h, s, 0)
+	})
+	e.progressive = progressive
+	if err != nil {
+		return err
+	}
+
+	h.trellis(scale, e.opts.TrellisDC)
+	return nil
+}
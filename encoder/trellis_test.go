@@ -0,0 +1,99 @@
+package encoder
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/yunomu/jpeg/decoder"
+)
+
+func testTexture(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1664525 + 1013904223
+			n := int(seed>>26) - 32
+			img.Set(x, y, color.RGBA{
+				R: uint8(min(max(x*4+n, 0), 255)),
+				G: uint8(min(max(y*5+n/2, 0), 255)),
+				B: uint8(min(max(128+(x^y)%32+n, 0), 255)),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+func TestEncode_trellis(t *testing.T) {
+	src := testTexture(64, 48)
+
+	for _, c := range []struct {
+		name string
+		opts Options
+	}{
+		{"ac", Options{OptimizeHuffman: true, Trellis: true}},
+		{"dc", Options{OptimizeHuffman: true, Trellis: true, TrellisDC: true}},
+		{"restart", Options{OptimizeHuffman: true, Trellis: true, TrellisDC: true, RestartInterval: 5}},
+		{"gray", Options{OptimizeHuffman: true, Trellis: true, TrellisDC: true, Quality: 90}},
+	} {
+		var img image.Image = src
+		if c.name == "gray" {
+			gray := image.NewGray(src.Bounds())
+			for i := range gray.Pix {
+				gray.Pix[i] = src.Pix[i*4]
+			}
+			img = gray
+		}
+
+		plain := c.opts
+		plain.Trellis, plain.TrellisDC = false, false
+		base := encode(t, img, &plain)
+		data := encode(t, img, &c.opts)
+		if len(data) >= len(base) {
+			t.Errorf("%s: size=%d plain=%d", c.name, len(data), len(base))
+		}
+
+		exp, err := decoder.Decode(bytes.NewReader(base))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		act, err := decoder.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		if e, e0 := meanError(t, img, act), meanError(t, img, exp); e > e0*1.2 {
+			t.Errorf("%s: mean error=%v plain=%v", c.name, e, e0)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("%s: jpeg.Decode: %v", c.name, err)
+		}
+
+		// the progressive image has the same coefficients
+		opts := c.opts
+		opts.Progressive = true
+		prog, err := decoder.Decode(bytes.NewReader(encode(t, img, &opts)))
+		if err != nil {
+			t.Fatalf("%s: Decode: %v", c.name, err)
+		}
+		if e := meanError(t, act, prog); e != 0 {
+			t.Errorf("%s: progressive: mean error=%v", c.name, e)
+		}
+	}
+}
+
+func TestEncode_trellisLambda(t *testing.T) {
+	src := testTexture(64, 48)
+
+	prev := 0
+	for _, l := range []float64{12, 14, 16, 18} {
+		n := len(encode(t, src, &Options{Trellis: true, TrellisLambda: l}))
+		if n < prev {
+			t.Errorf("lambda %v: size=%d < %d", l, n, prev)
+		}
+		prev = n
+	}
+}
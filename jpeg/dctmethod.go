@@ -0,0 +1,26 @@
+package jpeg
+
+// DCTMethod is the implementation of the forward and inverse DCT, as dct_method of libjpeg.
+// The outputs of each method are always the same for the same inputs.
+type DCTMethod int
+
+const (
+	DCTISlow  DCTMethod = iota // accurate integer (JDCT_ISLOW)
+	DCTIFast                   // fast and less accurate integer (JDCT_IFAST)
+	DCTFloat                   // AAN floating point (JDCT_FLOAT)
+	DCTMatrix                  // double precision matrix multiplication
+)
+
+func (m DCTMethod) String() string {
+	switch m {
+	case DCTISlow:
+		return "islow"
+	case DCTIFast:
+		return "ifast"
+	case DCTFloat:
+		return "float"
+	case DCTMatrix:
+		return "matrix"
+	}
+	return "unknown"
+}
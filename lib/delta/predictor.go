@@ -0,0 +1,106 @@
+package delta
+
+// Predictor is the predictor selection value of lossless JPEG (Table H.1).
+//
+//	0: no prediction
+//	1: Ra
+//	2: Rb
+//	3: Rc
+//	4: Ra + Rb - Rc
+//	5: Ra + ((Rb - Rc) >> 1)
+//	6: Rb + ((Ra - Rc) >> 1)
+//	7: (Ra + Rb) >> 1
+//
+// Ra, Rb and Rc are the reconstructed samples on the left, above and above-left.
+type Predictor uint8
+
+func (p Predictor) Valid() bool {
+	return p <= 7
+}
+
+// Predict returns the prediction of the sample at (x, y) of the samples pix
+// with precision prec (H.1.2.1). The prediction restarts at (x0, y0): the sample
+// there is predicted by 2^(prec-1), the rest of the line by Ra, and the first
+// samples of the following lines by Rb.
+func (p Predictor) Predict(pix []uint16, stride, x, y, x0, y0 int, prec uint8) int32 {
+	if p == 0 {
+		return 0
+	}
+
+	sample := func(x, y int) int32 {
+		return int32(pix[y*stride+x])
+	}
+
+	switch {
+	case x == x0 && y == y0:
+		return 1 << (prec - 1)
+	case y == y0:
+		return sample(x-1, y)
+	case x == 0:
+		return sample(x, y-1)
+	}
+
+	return p.PredictFrom(sample(x-1, y), sample(x, y-1), sample(x-1, y-1))
+}
+
+// PredictFrom returns the prediction from the neighboring samples Ra, Rb and Rc
+// of a sample not on the first line or column of the restart.
+func (p Predictor) PredictFrom(ra, rb, rc int32) int32 {
+	switch p {
+	case 0:
+		return 0
+	case 1:
+		return ra
+	case 2:
+		return rb
+	case 3:
+		return rc
+	case 4:
+		return ra + rb - rc
+	case 5:
+		return ra + (rb-rc)>>1
+	case 6:
+		return rb + (ra-rc)>>1
+	default:
+		return (ra + rb) >> 1
+	}
+}
+
+// Diff returns the difference of the sample from the prediction modulo 2^16,
+// in the range from -32767 to 32768 (H.1.2.2).
+func Diff(x uint16, pred int32) int32 {
+	d := (int32(x) - pred) & 0xFFFF
+	if d > 32768 {
+		d -= 0x10000
+	}
+	return d
+}
+
+// Reconstruct returns the sample of the difference from the prediction.
+func Reconstruct(diff, pred int32) uint16 {
+	return uint16(pred + diff)
+}
+
+// EncodePlane returns the differences of the w*h samples from their predictions.
+func EncodePlane(pix []uint16, w, h int, p Predictor, prec uint8) []int32 {
+	ret := make([]int32, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ret[y*w+x] = Diff(pix[y*w+x], p.Predict(pix, w, x, y, 0, 0, prec))
+		}
+	}
+
+	return ret
+}
+
+// DecodePlane returns the w*h samples reconstructed from the differences.
+func DecodePlane(diffs []int32, w, h int, p Predictor, prec uint8) []uint16 {
+	ret := make([]uint16, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ret[y*w+x] = Reconstruct(diffs[y*w+x], p.Predict(ret, w, x, y, 0, 0, prec))
+		}
+	}
+
+	return ret
+}
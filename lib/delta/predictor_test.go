@@ -0,0 +1,82 @@
+package delta
+
+import (
+	"testing"
+)
+
+func TestPredict(t *testing.T) {
+	pix := []uint16{
+		10, 20, 30,
+		40, 50, 61,
+	}
+
+	// the predictions at (2, 1): Ra=50, Rb=30, Rc=20
+	for p, exp := range []int32{0, 50, 30, 20, 60, 55, 45, 40} {
+		if act := Predictor(p).Predict(pix, 3, 2, 1, 0, 0, 8); act != exp {
+			t.Errorf("predictor %d: exp=%d act=%d", p, exp, act)
+		}
+		if act := Predictor(p).PredictFrom(50, 30, 20); act != exp {
+			t.Errorf("predictor %d: PredictFrom: exp=%d act=%d", p, exp, act)
+		}
+	}
+
+	for _, c := range []struct {
+		x, y, x0, y0 int
+		exp          int32
+	}{
+		{0, 0, 0, 0, 128},
+		{1, 0, 0, 0, 10},
+		{0, 1, 0, 0, 10},
+		{1, 1, 1, 1, 128},
+		{2, 1, 1, 1, 50},
+	} {
+		if act := Predictor(4).Predict(pix, 3, c.x, c.y, c.x0, c.y0, 8); act != c.exp {
+			t.Errorf("(%d, %d) from (%d, %d): exp=%d act=%d", c.x, c.y, c.x0, c.y0, c.exp, act)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	for _, c := range []struct {
+		x    uint16
+		pred int32
+		exp  int32
+	}{
+		{10, 3, 7},
+		{3, 10, -7},
+		{0, 32768, 32768},
+		{65535, 0, -1},
+		{0, 65535, 1},
+	} {
+		act := Diff(c.x, c.pred)
+		if act != c.exp {
+			t.Errorf("Diff(%d, %d): exp=%d act=%d", c.x, c.pred, c.exp, act)
+		}
+		if x := Reconstruct(act, c.pred); x != c.x {
+			t.Errorf("Reconstruct(%d, %d)=%d", act, c.pred, x)
+		}
+	}
+}
+
+func FuzzEncodePlane(f *testing.F) {
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 0xFF, 0xFE, 0}, uint8(3), uint8(4))
+	f.Fuzz(func(t *testing.T, data []byte, w uint8, p uint8) {
+		if w == 0 {
+			return
+		}
+		pix := make([]uint16, len(data)/2)
+		for i := range pix {
+			pix[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		}
+		h := len(pix) / int(w)
+		pix = pix[:int(w)*h]
+
+		pred := Predictor(p % 8)
+		decoded := DecodePlane(EncodePlane(pix, int(w), h, pred, 16), int(w), h, pred, 16)
+		for i := range pix {
+			if pix[i] != decoded[i] {
+				t.Fatalf("predictor %d: %d: before=%d after=%d", pred, i, pix[i], decoded[i])
+			}
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package huffman
 
 import (
+	"errors"
 	"io"
 	"sort"
 )
@@ -43,69 +44,170 @@ func histogram(r io.ByteReader) ([]*bin, error) {
 	return ret, nil
 }
 
-type node struct {
-	zero, one *node
-	count     int
-	b         byte
+// MaxCodeLength is the maximum length of the Huffman codes in JPEG.
+const MaxCodeLength = 16
+
+var (
+	ErrNoSymbol         = errors.New("no symbol")
+	ErrCodeSizeOverflow = errors.New("code size overflow")
+)
+
+// Frequencies is the number of occurrences of each symbol.
+type Frequencies [256]int
+
+func (f *Frequencies) Add(s byte) {
+	f[s]++
 }
 
-type nodeSlice []*node
+// Table is a Huffman table in the form of the DHT segment.
+type Table struct {
+	Bits   [MaxCodeLength]uint8 // BITS: number of codes of each length
+	Values []uint8              // HUFFVAL: symbols in order of increasing code length
+}
 
-func (n nodeSlice) Len() int               { return len(n) }
-func (n nodeSlice) Less(i int, j int) bool { return n[i].count < n[j].count }
-func (n nodeSlice) Swap(i int, j int)      { n[i], n[j] = n[j], n[i] }
+// Code is the Huffman code of a symbol.
+type Code struct {
+	Code uint16
+	Size uint8
+}
 
-func makeTree(bins []*bin) (*node, error) {
-	if len(bins) == 0 {
-		return nil, nil
+// Codes generates the codes of the symbols of the table (Annex C).
+// The size of the code of a symbol not in the table is 0.
+func (t *Table) Codes() *[256]Code {
+	var ret [256]Code
+
+	var code uint16
+	k := 0
+	for l, n := range t.Bits {
+		for i := 0; i < int(n); i++ {
+			ret[t.Values[k]] = Code{Code: code, Size: uint8(l + 1)}
+			code++
+			k++
+		}
+		code <<= 1
 	}
 
-	var nodes []*node
-	for _, bin := range bins {
-		nodes = append(nodes, &node{
-			count: bin.count,
-			b:     bin.b,
-		})
+	return &ret
+}
+
+// codeSizes finds the code size of each symbol with the procedure of Figure K.1.
+// The symbol 256 is reserved with a frequency of 1 so that no code consists of all 1-bits.
+func codeSizes(f *Frequencies) [257]int {
+	var freq [257]int
+	copy(freq[:], f[:])
+	freq[256] = 1
+
+	var codesize [257]int
+	var others [257]int
+	for i := range others {
+		others[i] = -1
 	}
 
 	for {
-		switch len(nodes) {
-		case 0:
-			panic("unexpected state")
-		case 1:
-			return nodes[0], nil
-		default:
+		// V1 is the least frequency, and V2 the next least, preferring the larger symbol
+		v1, v2 := -1, -1
+		for i, n := range freq {
+			if n != 0 && (v1 < 0 || n <= freq[v1]) {
+				v1 = i
+			}
+		}
+		for i, n := range freq {
+			if n != 0 && i != v1 && (v2 < 0 || n <= freq[v2]) {
+				v2 = i
+			}
+		}
+		if v2 < 0 {
+			break
+		}
+
+		freq[v1] += freq[v2]
+		freq[v2] = 0
+
+		codesize[v1]++
+		for others[v1] >= 0 {
+			v1 = others[v1]
+			codesize[v1]++
+		}
+		others[v1] = v2
+
+		codesize[v2]++
+		for others[v2] >= 0 {
+			v2 = others[v2]
+			codesize[v2]++
 		}
+	}
+
+	return codesize
+}
+
+// Build builds the table of the symbols with non-zero frequencies as in Annex K.2.
+func Build(f *Frequencies) (*Table, error) {
+	if *f == (Frequencies{}) {
+		return nil, ErrNoSymbol
+	}
+
+	codesize := codeSizes(f)
 
-		zero, one := nodes[0], nodes[1]
-		node := &node{
-			zero:  zero,
-			one:   one,
-			count: zero.count + one.count,
+	// Figure K.2
+	var bits [33]int
+	for _, s := range codesize {
+		if s != 0 {
+			if s >= len(bits) {
+				return nil, ErrCodeSizeOverflow
+			}
+			bits[s]++
 		}
+	}
 
-		var newNodes []*node
-		for i, n_ := range nodes[1:] {
-			n := n_
-			if node.count <= n.count {
-				newNodes = append(newNodes, node, nodes[i+1:]...)
+	// Figure K.3
+	for i := len(bits) - 1; i > MaxCodeLength; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
 			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
 		}
+	}
 
-		var _ = node
+	// remove the reserved code from the longest length
+	i := MaxCodeLength
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	var ret Table
+	for l := 1; l <= MaxCodeLength; l++ {
+		ret.Bits[l-1] = uint8(bits[l])
+	}
+
+	// Figure K.4
+	for l := 1; l < len(bits); l++ {
+		for s := 0; s < 256; s++ {
+			if codesize[s] == l {
+				ret.Values = append(ret.Values, uint8(s))
+			}
+		}
 	}
-}
 
-type Entry struct {
-	orig byte
+	return &ret, nil
 }
 
-func Encode(r io.ByteReader) error {
+// Encode builds the table of the bytes read from r.
+func Encode(r io.ByteReader) (*Table, error) {
 	bins, err := histogram(r)
 	if err != nil {
-		return nil
+		return nil, err
+	}
+
+	var f Frequencies
+	for _, bin := range bins {
+		f[bin.b] = bin.count
 	}
 
-	var _ = bins
-	panic("not implements")
+	return Build(&f)
 }
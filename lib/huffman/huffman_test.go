@@ -33,3 +33,108 @@ func TestHistogram(t *testing.T) {
 		}
 	}
 }
+
+// checkTable checks that the codes of the table are a complete prefix code
+// except for the code of all 1-bits of the longest length.
+func checkTable(t *testing.T, tbl *Table) {
+	t.Helper()
+
+	n := 0
+	kraft := 0
+	longest := 0
+	for l, c := range tbl.Bits {
+		n += int(c)
+		kraft += int(c) << (MaxCodeLength - 1 - l)
+		if c != 0 {
+			longest = l
+		}
+	}
+	if n != len(tbl.Values) {
+		t.Fatalf("number of codes=%d values=%d", n, len(tbl.Values))
+	}
+	if kraft+1<<(MaxCodeLength-1-longest) != 1<<MaxCodeLength {
+		t.Errorf("Kraft sum=%d", kraft)
+	}
+
+	codes := tbl.Codes()
+	for _, v := range tbl.Values {
+		c := codes[v]
+		if c.Code == 1<<c.Size-1 {
+			t.Errorf("code of %d is all 1-bits: size=%d", v, c.Size)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	var f Frequencies
+	f[0x10] = 100
+	f[0x20] = 50
+	f[0x30] = 25
+	f[0x40] = 25
+
+	tbl, err := Build(&f)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	checkTable(t, tbl)
+
+	if exp := [MaxCodeLength]uint8{1, 1, 1, 1}; tbl.Bits != exp {
+		t.Errorf("BITS=%v", tbl.Bits)
+	}
+	if exp := []uint8{0x10, 0x20, 0x30, 0x40}; !bytes.Equal(tbl.Values, exp) {
+		t.Errorf("HUFFVAL=%v", tbl.Values)
+	}
+}
+
+func TestBuild_lengthLimit(t *testing.T) {
+	// Fibonacci frequencies make a code of 39 bits without the limit
+	var f Frequencies
+	a, b := 1, 1
+	for i := 0; i < 40; i++ {
+		f[i] = a
+		a, b = b, a+b
+	}
+
+	tbl, err := Build(&f)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	checkTable(t, tbl)
+
+	if len(tbl.Values) != 40 {
+		t.Errorf("number of values=%d", len(tbl.Values))
+	}
+	if !bytes.Equal(tbl.Values[:2], []uint8{38, 39}) {
+		t.Errorf("most frequent symbols=%v", tbl.Values[:2])
+	}
+}
+
+func TestBuild_single(t *testing.T) {
+	var f Frequencies
+	if _, err := Build(&f); err != ErrNoSymbol {
+		t.Errorf("empty: err=%v", err)
+	}
+
+	f[7] = 3
+	tbl, err := Build(&f)
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	checkTable(t, tbl)
+
+	if tbl.Bits[0] != 1 || !bytes.Equal(tbl.Values, []uint8{7}) {
+		t.Errorf("BITS=%v HUFFVAL=%v", tbl.Bits, tbl.Values)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tbl, err := Encode(bytes.NewReader([]byte("abracadabra")))
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	checkTable(t, tbl)
+
+	if tbl.Values[0] != 'a' {
+		t.Errorf("HUFFVAL=%q", tbl.Values)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"flag"
 	"image"
 	"image/color"
-	"image/jpeg"
 	"log/slog"
 	"os"
+
+	_ "github.com/yunomu/jpeg/decoder/register"
+	"github.com/yunomu/jpeg/encoder"
 )
 
 var (
@@ -46,8 +48,8 @@ func main() {
 		}
 	}
 
-	if err := jpeg.Encode(os.Stdout, out, nil); err != nil {
-		slog.Error("jpeg.Encode", "err", err)
+	if err := encoder.Encode(os.Stdout, out, nil); err != nil {
+		slog.Error("encoder.Encode", "err", err)
 		return
 	}
 }